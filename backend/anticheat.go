@@ -0,0 +1,167 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// --- Anti-cheat ---
+//
+// Points farming is limited at award time (daily caps per event type,
+// content quality for comments, no points for commenting on your own
+// places), at submission (new places per user per day) and detected after
+// the fact (velocity flags for admins, who can
+// claw back a user's points).
+
+var commentMinLength = envInt("COMMENT_MIN_LENGTH", 15)                // Letters and digits, punctuation ignored
+var duplicateWindow = envDuration("DUPLICATE_WINDOW", 30*24*time.Hour) // How far back duplicate comments are checked
+var velocityThreshold = envInt("VELOCITY_FLAG_THRESHOLD", 30)          // Awards per hour before flagging
+var placeDailyLimit = envInt("PLACE_DAILY_LIMIT", 10)                  // New places per user per 24 hours
+
+const flagVelocity = "velocity"
+
+type AbuseFlag struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Username   string     `json:"username"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+func initAbuseTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS abuse_flags (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		reason TEXT NOT NULL,
+		details TEXT DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		resolved_at TIMESTAMP
+	)`)
+}
+
+// normalizeContent folds case, drops punctuation and collapses whitespace
+// so trivially edited copies compare equal.
+func normalizeContent(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			space = false
+		case unicode.IsSpace(r) && !space && b.Len() > 0:
+			b.WriteRune(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// commentEarnsPoints decides whether a freshly inserted comment should be
+// rewarded. The comment itself is kept either way.
+func commentEarnsPoints(tx *sql.Tx, userID int, c Comment) bool {
+	normalized := normalizeContent(c.Content)
+	if len([]rune(normalized)) < commentMinLength { return false }
+
+	var creatorID int
+	tx.QueryRow("SELECT COALESCE(creator_id, 0) FROM places WHERE id = $1", c.PlaceID).Scan(&creatorID)
+	if creatorID == userID { return false }
+
+	rows, err := tx.Query("SELECT content FROM comments WHERE user_id = $1 AND id <> $2 AND created_at > $3 ORDER BY id DESC LIMIT 200", userID, c.ID, time.Now().Add(-duplicateWindow))
+	if err != nil { return false }
+	defer rows.Close()
+	for rows.Next() {
+		var previous string
+		rows.Scan(&previous)
+		if normalizeContent(previous) == normalized { return false }
+	}
+	return true
+}
+
+// dailyCapReached reports whether the user already got cap standing awards
+// for eventType today. A cap of 0 means unlimited.
+func dailyCapReached(tx *sql.Tx, userID int, eventType string, cap int) (bool, error) {
+	if cap <= 0 { return false, nil }
+	var n int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM points_ledger l
+		WHERE l.user_id = $1 AND l.event_type = $2 AND l.reverses IS NULL AND l.created_at >= date_trunc('day', now())
+		AND NOT EXISTS (SELECT 1 FROM points_ledger r WHERE r.reverses = l.id)`, userID, eventType).Scan(&n)
+	return n >= cap, err
+}
+
+// checkVelocity flags users earning awards faster than velocityThreshold
+// per hour. Only one open velocity flag is kept per user.
+func checkVelocity(tx *sql.Tx, userID int) error {
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM points_ledger WHERE user_id = $1 AND reverses IS NULL AND points > 0 AND created_at > now() - interval '1 hour'", userID).Scan(&n); err != nil { return err }
+	if n <= velocityThreshold { return nil }
+	_, err := tx.Exec(`INSERT INTO abuse_flags (user_id, reason, details)
+		SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM abuse_flags WHERE user_id = $1 AND reason = $2 AND resolved_at IS NULL)`,
+		userID, flagVelocity, fmt.Sprintf("%d awards in the last hour", n))
+	return err
+}
+
+// abuseAdmin backs the anti-cheat actions of /api/admin:
+//   GET  flags        open flags (?all=1 includes resolved)
+//   POST resolve-flag {"id"}
+//   POST clawback     {"user_id", "since", "event_type"} reverses standing awards for good
+func abuseAdmin(w http.ResponseWriter, r *http.Request, action string) {
+	switch {
+	case action == "flags" && r.Method == "GET":
+		query := "SELECT f.id, f.user_id, u.username, f.reason, COALESCE(f.details, ''), f.created_at, f.resolved_at FROM abuse_flags f JOIN users u ON u.id = f.user_id"
+		if r.URL.Query().Get("all") == "" { query += " WHERE f.resolved_at IS NULL" }
+		rows, err := db.Query(query + " ORDER BY f.created_at DESC")
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		defer rows.Close()
+		flags := []AbuseFlag{}
+		for rows.Next() {
+			var f AbuseFlag
+			rows.Scan(&f.ID, &f.UserID, &f.Username, &f.Reason, &f.Details, &f.CreatedAt, &f.ResolvedAt)
+			flags = append(flags, f)
+		}
+		json.NewEncoder(w).Encode(flags)
+	case action == "resolve-flag" && r.Method == "POST":
+		var req struct { ID int `json:"id"` }
+		json.NewDecoder(r.Body).Decode(&req)
+		db.Exec("UPDATE abuse_flags SET resolved_at = CURRENT_TIMESTAMP WHERE id = $1 AND resolved_at IS NULL", req.ID)
+		w.WriteHeader(http.StatusOK)
+	case action == "clawback" && r.Method == "POST":
+		var req struct {
+			UserID    int       `json:"user_id"`
+			Since     time.Time `json:"since"`
+			EventType string    `json:"event_type"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 { http.Error(w, "Invalid request", http.StatusBadRequest); return }
+		var reversed, points int
+		err := withTx(func(tx *sql.Tx) error {
+			rows, err := tx.Query(`SELECT l.id, l.user_id, l.points FROM points_ledger l
+				WHERE l.user_id = $1 AND l.reverses IS NULL AND l.points > 0 AND l.created_at >= $2 AND ($3 = '' OR l.event_type = $3)
+				AND NOT EXISTS (SELECT 1 FROM points_ledger r WHERE r.reverses = l.id)`, req.UserID, req.Since, req.EventType)
+			if err != nil { return err }
+			var entries []LedgerEntry
+			for rows.Next() {
+				var e LedgerEntry
+				rows.Scan(&e.ID, &e.UserID, &e.Points)
+				entries = append(entries, e)
+			}
+			rows.Close()
+			for _, e := range entries {
+				if err := reverseEntry(tx, e, true); err != nil { return err }
+				reversed++
+				points += e.Points
+			}
+			return nil
+		})
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		json.NewEncoder(w).Encode(map[string]int{"reversed_entries": reversed, "points_removed": points})
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Place attributes ---
+//
+// Opening hours, pricing and amenities. Prices are ticket prices with
+// their own currency; price_level is the coarse 0 (free) to 4 scale used
+// for filtering. Amenity values follow OSM (wheelchair=yes|limited|no).
+
+var placesTimezone = getEnv("PLACES_TIMEZONE", "Europe/Istanbul") // For places without their own
+
+const maxPriceLevel = 4
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type TicketPrice struct {
+	Label    string  `json:"label"` // e.g. "adult", "student"
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"` // ISO 4217
+}
+
+type Amenities struct {
+	Parking    *bool  `json:"parking,omitempty"`
+	Wheelchair string `json:"wheelchair,omitempty"` // yes, limited or no
+	Toilets    *bool  `json:"toilets,omitempty"`
+}
+
+// PlaceAttributes are the optional fields shared by Place and PlaceRequest.
+type PlaceAttributes struct {
+	OpeningHours string        `json:"opening_hours,omitempty"` // OSM opening_hours syntax
+	Timezone     string        `json:"timezone,omitempty"`
+	PriceLevel   *int          `json:"price_level,omitempty"`
+	Prices       []TicketPrice `json:"prices,omitempty"`
+	Amenities    *Amenities    `json:"amenities,omitempty"`
+}
+
+var amenityFilters = map[string]string{
+	"parking":    `p.amenities @> '{"parking": true}'`,
+	"toilets":    `p.amenities @> '{"toilets": true}'`,
+	"wheelchair": `p.amenities @> '{"wheelchair": "yes"}'`,
+}
+
+func initAttributeColumns() {
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS opening_hours TEXT")
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS timezone TEXT")
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS price_level INT")
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS prices JSONB")
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS amenities JSONB")
+	// The old price column was never exposed; carry any values over as a
+	// ticket price once
+	db.Exec(`UPDATE places SET prices = jsonb_build_array(jsonb_build_object('label', '', 'amount', price, 'currency', 'TRY'))
+		WHERE price > 0 AND prices IS NULL`)
+}
+
+// validateAttributes normalizes a and returns a message for bad input.
+func validateAttributes(a *PlaceAttributes) string {
+	a.OpeningHours = strings.TrimSpace(a.OpeningHours)
+	if a.OpeningHours != "" {
+		if _, err := parseOpeningHours(a.OpeningHours); err != nil { return "opening_hours: " + err.Error() }
+	}
+	if a.Timezone != "" {
+		if _, err := time.LoadLocation(a.Timezone); err != nil { return "Unknown timezone" }
+	}
+	if a.PriceLevel != nil && (*a.PriceLevel < 0 || *a.PriceLevel > maxPriceLevel) { return fmt.Sprintf("price_level must be between 0 and %d", maxPriceLevel) }
+	for i := range a.Prices {
+		p := &a.Prices[i]
+		p.Currency = strings.ToUpper(strings.TrimSpace(p.Currency))
+		if !currencyPattern.MatchString(p.Currency) { return "prices: currency must be an ISO 4217 code" }
+		if p.Amount < 0 || len(p.Label) > 50 { return "prices: invalid entry" }
+	}
+	if a.Amenities != nil {
+		switch a.Amenities.Wheelchair {
+		case "", "yes", "limited", "no":
+		default:
+			return "amenities.wheelchair must be yes, limited or no"
+		}
+	}
+	return ""
+}
+
+// attributeArgs are the INSERT/UPDATE values for opening_hours, timezone,
+// price_level, prices and amenities, in that order.
+func attributeArgs(a PlaceAttributes) []interface{} {
+	var level sql.NullInt64
+	if a.PriceLevel != nil { level = sql.NullInt64{Int64: int64(*a.PriceLevel), Valid: true} }
+	var prices, amenities interface{}
+	if len(a.Prices) > 0 { b, _ := json.Marshal(a.Prices); prices = string(b) }
+	if a.Amenities != nil { b, _ := json.Marshal(a.Amenities); amenities = string(b) }
+	return []interface{}{sql.NullString{String: a.OpeningHours, Valid: a.OpeningHours != ""}, sql.NullString{String: a.Timezone, Valid: a.Timezone != ""}, level, prices, amenities}
+}
+
+// placeAttributeColumns selects what placeAttributeScan reads; the table
+// must be aliased p.
+const placeAttributeColumns = `COALESCE(p.opening_hours, ''), COALESCE(p.timezone, ''), p.price_level, p.prices, p.amenities`
+
+type placeAttributeScan struct {
+	hours, tz         string
+	level             sql.NullInt64
+	prices, amenities []byte
+}
+
+func (s *placeAttributeScan) dest() []interface{} {
+	return []interface{}{&s.hours, &s.tz, &s.level, &s.prices, &s.amenities}
+}
+
+// apply copies the scanned attributes into p and computes OpenNow for now.
+func (s *placeAttributeScan) apply(p *Place, now time.Time) {
+	p.OpeningHours, p.Timezone = s.hours, s.tz
+	if s.level.Valid { level := int(s.level.Int64); p.PriceLevel = &level }
+	if s.prices != nil { json.Unmarshal(s.prices, &p.Prices) }
+	if s.amenities != nil { p.Amenities = &Amenities{}; json.Unmarshal(s.amenities, p.Amenities) }
+	p.OpenNow = openNow(p.OpeningHours, p.Timezone, now)
+}
+
+// openNow is nil when the hours are unknown or unparseable.
+func openNow(hours, tz string, now time.Time) *bool {
+	if hours == "" { return nil }
+	h, err := parseOpeningHours(hours)
+	if err != nil { return nil }
+	if tz == "" { tz = placesTimezone }
+	loc, err := time.LoadLocation(tz)
+	if err != nil { return nil }
+	open := h.openAt(now.In(loc))
+	return &open
+}
+
+// attributeFilters turns the GET /api/places filters max_price_level=,
+// free=1 and amenities=parking,wheelchair,toilets into SQL conditions
+// numbered after args. open_now is applied after scanning.
+func attributeFilters(q url.Values, args []interface{}) ([]string, []interface{}, string) {
+	var conds []string
+	if s := q.Get("max_price_level"); s != "" {
+		level, err := strconv.Atoi(s)
+		if err != nil || level < 0 || level > maxPriceLevel { return nil, nil, "Invalid max_price_level" }
+		args = append(args, level)
+		conds = append(conds, fmt.Sprintf("p.price_level <= $%d", len(args)))
+	}
+	if q.Get("free") == "1" { conds = append(conds, "p.price_level = 0") }
+	if s := q.Get("amenities"); s != "" {
+		for _, a := range strings.Split(s, ",") {
+			cond, ok := amenityFilters[strings.TrimSpace(a)]
+			if !ok { return nil, nil, "Unknown amenity: " + a }
+			conds = append(conds, cond)
+		}
+	}
+	return conds, args, ""
+}
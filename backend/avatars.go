@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// --- Avatars ---
+
+// AvatarVariants are the fixed square sizes every avatar is served at.
+type AvatarVariants struct {
+	Small  string `json:"small"`  // 64px
+	Medium string `json:"medium"` // 128px
+	Large  string `json:"large"`  // 256px
+}
+
+// avatarSizes lists the generated sizes, largest first. The largest is
+// stored under the avatar's own key, the rest as "_s<size>" variants.
+var avatarSizes = []int{256, 128, 64}
+
+const avatarKeyPrefix = "avatar-"
+
+var errNotYourUpload = errors.New("avatar must be one of your own uploads")
+
+func avatarVariantName(key string, size int) string {
+	if size == avatarSizes[0] { return key }
+	ext := key[strings.LastIndexByte(key, '.'):]
+	return fmt.Sprintf("%s_s%d%s", strings.TrimSuffix(key, ext), size, ext)
+}
+
+func identiconURL(userID, size int) string {
+	return fmt.Sprintf("/api/avatar?id=%d&s=%d", userID, size)
+}
+
+// userAvatar returns the avatar URL and variants to show for a user. Only
+// our own uploads are ever returned; anything else (legacy external URLs)
+// falls back to the identicon so profiles can't embed tracking pixels.
+func userAvatar(userID int, avatarURL string) (string, *AvatarVariants) {
+	key := blobKeyFromURL(avatarURL)
+	if key == "" {
+		return identiconURL(userID, 256), &AvatarVariants{Small: identiconURL(userID, 64), Medium: identiconURL(userID, 128), Large: identiconURL(userID, 256)}
+	}
+	if !strings.HasPrefix(key, avatarKeyPrefix) {
+		// Uploaded before the avatar endpoint existed, no square variants
+		return avatarURL, &AvatarVariants{Small: avatarURL, Medium: avatarURL, Large: avatarURL}
+	}
+	return blobs.URL(key), &AvatarVariants{
+		Small:  blobs.URL(avatarVariantName(key, 64)),
+		Medium: blobs.URL(avatarVariantName(key, 128)),
+		Large:  blobs.URL(key),
+	}
+}
+
+// validateAvatarURL checks a user-supplied avatar_url. It returns the value
+// to store: "" for no avatar or an identicon, otherwise an upload that
+// belongs to the user.
+func validateAvatarURL(userID int, avatarURL string) (string, error) {
+	if avatarURL == "" || strings.HasPrefix(avatarURL, "/api/avatar?") { return "", nil }
+	key := blobKeyFromURL(avatarURL)
+	if key == "" { return "", errNotYourUpload }
+	var ownerID int
+	if err := db.QueryRow("SELECT COALESCE(owner_id, 0) FROM uploads WHERE key = $1", key).Scan(&ownerID); err != nil || ownerID != userID {
+		return "", errNotYourUpload
+	}
+	return blobs.URL(key), nil
+}
+
+// cropSquare cuts a square out of img. x, y and size describe the requested
+// focal region in source pixels; a non-positive size means the largest
+// centered square. The region is clamped to the image.
+func cropSquare(img image.Image, x, y, size int) image.Image {
+	b := img.Bounds()
+	maxSize := b.Dx()
+	if b.Dy() < maxSize { maxSize = b.Dy() }
+	if size <= 0 || size > maxSize {
+		if size <= 0 { x, y = (b.Dx()-maxSize)/2, (b.Dy()-maxSize)/2 }
+		size = maxSize
+	}
+	if x < 0 { x = 0 }
+	if y < 0 { y = 0 }
+	if x+size > b.Dx() { x = b.Dx() - size }
+	if y+size > b.Dy() { y = b.Dy() - size }
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), img, image.Pt(b.Min.X+x, b.Min.Y+y), draw.Src)
+	return dst
+}
+
+// avatarUploadHandler backs POST /api/user?action=avatar. It takes the
+// "image" form file plus optional x, y and size crop fields, stores the
+// square variants and makes the result the user's avatar.
+func avatarUploadHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) { http.Error(w, "File too large", http.StatusRequestEntityTooLarge); return }
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	file, _, err := r.FormFile("image")
+	if err != nil { http.Error(w, "Error retrieving file", http.StatusBadRequest); return }
+	defer file.Close()
+
+	img, _, _, err := sanitizeImage(file)
+	if err != nil {
+		if errors.Is(err, errImageTooLarge) { http.Error(w, "Image dimensions too large", http.StatusRequestEntityTooLarge); return }
+		http.Error(w, "Invalid file type", http.StatusBadRequest)
+		return
+	}
+	x, _ := strconv.Atoi(r.FormValue("x"))
+	y, _ := strconv.Atoi(r.FormValue("y"))
+	size, _ := strconv.Atoi(r.FormValue("size"))
+	square := cropSquare(img, x, y, size)
+
+	key := avatarKeyPrefix + randomName() + ".jpg"
+	for _, s := range avatarSizes {
+		data, err := encodeImage(scaleImage(square, s, s), ".jpg")
+		if err == nil { err = blobs.Put(r.Context(), avatarVariantName(key, s), data, "image/jpeg") }
+		if err != nil {
+			log.Printf("Avatar: store failed: %v", err)
+			http.Error(w, "Error saving file", http.StatusInternalServerError)
+			return
+		}
+	}
+	trackUpload(key, userID)
+
+	var oldAvatar string
+	db.QueryRow("SELECT COALESCE(avatar_url, '') FROM users WHERE id=$1", userID).Scan(&oldAvatar)
+	db.Exec("UPDATE users SET avatar_url=$1 WHERE id=$2", blobs.URL(key), userID)
+	releaseUpload(oldAvatar)
+	retainUpload(blobs.URL(key))
+
+	avatarURL, avatars := userAvatar(userID, blobs.URL(key))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"avatar_url": avatarURL, "avatars": avatars})
+}
+
+// identicon draws a GitHub-style 5x5 mirrored pattern derived from the
+// user ID, used when a user has no avatar of their own.
+func identicon(userID, size int) image.Image {
+	sum := sha256.Sum256([]byte("maplas-identicon:" + strconv.Itoa(userID)))
+	fg := color.RGBA{R: 64 + sum[0]%160, G: 64 + sum[1]%160, B: 64 + sum[2]%160, A: 255}
+	bg := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+	pad := size / 10
+	cell := (size - 2*pad) / 5
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 3; col++ {
+			if sum[3+row*3+col]%2 == 0 { continue }
+			for _, c := range []int{col, 4 - col} {
+				rect := image.Rect(pad+c*cell, pad+row*cell, pad+(c+1)*cell, pad+(row+1)*cell)
+				draw.Draw(img, rect, &image.Uniform{fg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return img
+}
+
+// identiconHandler serves GET /api/avatar?id=<user id>&s=<size>.
+func identiconHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "GET" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || userID <= 0 { http.Error(w, "Invalid user ID", http.StatusBadRequest); return }
+	size := avatarSizes[0]
+	if s, err := strconv.Atoi(r.URL.Query().Get("s")); err == nil {
+		for _, as := range avatarSizes {
+			if s <= as { size = as }
+		}
+	}
+	etag := fmt.Sprintf(`"identicon-%d-%d"`, userID, size)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if r.Header.Get("If-None-Match") == etag { w.WriteHeader(http.StatusNotModified); return }
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, identicon(userID, size))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Badges and achievements ---
+//
+// Badge definitions live in code; the user_badges table only records when
+// each one was earned. Badges are re-evaluated whenever the points ledger
+// gets a new award, and once earned they are kept.
+
+type Badge struct {
+	ID          string            `json:"id"`
+	Icon        string            `json:"icon"`
+	Title       map[string]string `json:"title"`
+	Description map[string]string `json:"description"`
+	Target      int               `json:"target"`
+	metric      func(q querier, userID int) (int, error)
+}
+
+// UserBadge is a badge together with one user's progress towards it.
+type UserBadge struct {
+	Badge
+	Progress int        `json:"progress"`
+	Earned   bool       `json:"earned"`
+	EarnedAt *time.Time `json:"earned_at,omitempty"`
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+var badges = []Badge{
+	{
+		ID: "first_place", Icon: "📍", Target: 1,
+		Title:       map[string]string{"tr": "İlk Adım", "en": "First Step"},
+		Description: map[string]string{"tr": "İlk mekanın onaylandı", "en": "Your first place was approved"},
+		metric:      approvedPlacesMetric,
+	},
+	{
+		ID: "ten_cities", Icon: "🏙️", Target: 10,
+		Title:       map[string]string{"tr": "Şehir Gezgini", "en": "City Hopper"},
+		Description: map[string]string{"tr": "10 farklı şehir keşfettin", "en": "Explored 10 different cities"},
+		metric:      citiesVisitedMetric,
+	},
+	{
+		ID: "hundred_reviews", Icon: "✍️", Target: 100,
+		Title:       map[string]string{"tr": "Eleştirmen", "en": "Critic"},
+		Description: map[string]string{"tr": "100 yorum yazdın", "en": "Wrote 100 reviews"},
+		metric:      reviewsMetric,
+	},
+	{
+		ID: "all_categories", Icon: "🧩", Target: 0, // Target is the number of taxonomy categories in use, see badgeTarget
+		Title:       map[string]string{"tr": "Her Telden", "en": "Well Rounded"},
+		Description: map[string]string{"tr": "Her kategoriden bir mekan keşfettin", "en": "Explored a place in every category"},
+		metric:      categoriesMetric,
+	},
+}
+
+// userPlacesSQL is every approved place the user added, reviewed or
+// checked in to.
+const userPlacesSQL = `
+	SELECT p.id, p.city, p.category FROM places p WHERE p.status = 'approved' AND p.creator_id = $1
+	UNION
+	SELECT p.id, p.city, p.category FROM places p JOIN comments c ON c.place_id = p.id WHERE p.status = 'approved' AND c.user_id = $1
+	UNION
+	SELECT p.id, p.city, p.category FROM places p JOIN checkins ci ON ci.place_id = p.id WHERE p.status = 'approved' AND ci.user_id = $1`
+
+func approvedPlacesMetric(q querier, userID int) (int, error) {
+	var n int
+	err := q.QueryRow("SELECT COUNT(*) FROM places WHERE status = 'approved' AND creator_id = $1", userID).Scan(&n)
+	return n, err
+}
+
+func citiesVisitedMetric(q querier, userID int) (int, error) {
+	var n int
+	err := q.QueryRow("SELECT COUNT(DISTINCT city) FROM ("+userPlacesSQL+") up WHERE COALESCE(city, '') <> ''", userID).Scan(&n)
+	return n, err
+}
+
+func reviewsMetric(q querier, userID int) (int, error) {
+	var n int
+	err := q.QueryRow("SELECT COUNT(*) FROM comments WHERE user_id = $1", userID).Scan(&n)
+	return n, err
+}
+
+// categorySlugs lists the managed categories; places left with a deleted
+// or unknown slug don't count towards all_categories.
+func categorySlugs() []string {
+	var slugs []string
+	if set := taxonomy.Load(); set != nil {
+		for _, c := range set.list { slugs = append(slugs, c.Slug) }
+	}
+	return slugs
+}
+
+func categoriesMetric(q querier, userID int) (int, error) {
+	var n int
+	err := q.QueryRow("SELECT COUNT(DISTINCT category) FROM ("+userPlacesSQL+") up WHERE category = ANY($2)", userID, pq.Array(categorySlugs())).Scan(&n)
+	return n, err
+}
+
+// badgeTarget resolves targets that depend on the data set.
+func badgeTarget(q querier, b Badge) int {
+	if b.ID == "all_categories" {
+		var n int
+		q.QueryRow("SELECT COUNT(DISTINCT category) FROM places WHERE status = 'approved' AND category = ANY($1)", pq.Array(categorySlugs())).Scan(&n)
+		return n
+	}
+	return b.Target
+}
+
+func initBadgeTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS user_badges (
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		badge_id TEXT NOT NULL,
+		earned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, badge_id)
+	)`)
+}
+
+// evaluateBadges grants every badge the user now qualifies for. It is
+// called from the ledger after each award.
+func evaluateBadges(tx *sql.Tx, userID int) error {
+	for _, b := range badges {
+		var has bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM user_badges WHERE user_id = $1 AND badge_id = $2)", userID, b.ID).Scan(&has); err != nil { return err }
+		if has { continue }
+		progress, err := b.metric(tx, userID)
+		if err != nil { return err }
+		target := badgeTarget(tx, b)
+		if target <= 0 || progress < target { continue }
+		if _, err := tx.Exec("INSERT INTO user_badges (user_id, badge_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", userID, b.ID); err != nil { return err }
+	}
+	return nil
+}
+
+// userBadges lists every badge with the user's progress and earned state.
+func userBadges(userID int) ([]UserBadge, error) {
+	earned := map[string]time.Time{}
+	rows, err := db.Query("SELECT badge_id, earned_at FROM user_badges WHERE user_id = $1", userID)
+	if err != nil { return nil, err }
+	for rows.Next() {
+		var id string
+		var at time.Time
+		rows.Scan(&id, &at)
+		earned[id] = at
+	}
+	rows.Close()
+
+	result := []UserBadge{}
+	for _, b := range badges {
+		ub := UserBadge{Badge: b}
+		ub.Target = badgeTarget(db, b)
+		ub.Progress, _ = b.metric(db, userID)
+		if at, ok := earned[b.ID]; ok {
+			ub.Earned = true
+			ub.EarnedAt = &at
+			if ub.Progress < ub.Target { ub.Progress = ub.Target }
+		}
+		if ub.Progress > ub.Target && ub.Target > 0 { ub.Progress = ub.Target }
+		result = append(result, ub)
+	}
+	return result, nil
+}
+
+// badgesHandler serves GET /api/badges. Without parameters it lists the
+// available badges; with user_id it returns that user's earned and
+// in-progress badges, filtered by ?status=earned|in_progress.
+func badgesHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "GET" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	userIDStr := r.URL.Query().Get("user_id")
+	if userIDStr == "" {
+		list := make([]Badge, len(badges))
+		for i, b := range badges {
+			list[i] = b
+			list[i].Target = badgeTarget(db, b)
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil { http.Error(w, "Invalid user ID", http.StatusBadRequest); return }
+	list, err := userBadges(userID)
+	if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+	status := r.URL.Query().Get("status")
+	filtered := []UserBadge{}
+	for _, ub := range list {
+		if (status == "earned" && !ub.Earned) || (status == "in_progress" && ub.Earned) { continue }
+		filtered = append(filtered, ub)
+	}
+	json.NewEncoder(w).Encode(filtered)
+}
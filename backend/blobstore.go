@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Blob storage ---
+
+// BlobStore stores uploaded files under flat keys such as "abc123.jpg".
+// Handlers only talk to the global blobs store, so the backend can be
+// switched from local disk to S3 without touching upload code.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error)
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context) ([]BlobInfo, error)
+	// URL is the permanent public URL stored in image_url/avatar_url.
+	URL(key string) string
+	// SignedURL is a time-limited URL that can be handed to clients.
+	SignedURL(key string, ttl time.Duration) (string, error)
+}
+
+// BlobInfo describes a stored object.
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+var errBlobNotFound = errors.New("blob not found")
+
+// blobs is the store used by the upload handlers, set up in main.
+var blobs BlobStore
+
+const signedURLTTL = 15 * time.Minute
+
+// newBlobStore builds the store selected by BLOB_BACKEND ("local" or "s3").
+func newBlobStore() (BlobStore, error) {
+	switch getEnv("BLOB_BACKEND", "local") {
+	case "local":
+		return newLocalBlobStore(getEnv("UPLOAD_DIR", uploadDir))
+	case "s3":
+		return newS3BlobStore(s3Config{
+			Endpoint:  getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			Bucket:    getEnv("S3_BUCKET", ""),
+			AccessKey: getEnv("S3_ACCESS_KEY", ""),
+			SecretKey: getEnv("S3_SECRET_KEY", ""),
+			PublicURL: getEnv("S3_PUBLIC_URL", ""),
+		})
+	}
+	return nil, fmt.Errorf("unknown BLOB_BACKEND %q", getEnv("BLOB_BACKEND", ""))
+}
+
+// validBlobKey rejects anything that could escape a flat namespace.
+func validBlobKey(key string) bool {
+	return key != "" && key == filepath.Base(key) && !strings.HasPrefix(key, ".") && !strings.ContainsAny(key, "/\\")
+}
+
+// blobKeyFromURL extracts the key from a URL produced by blobs.URL or by
+// earlier versions that always used /uploads/. External URLs return "".
+func blobKeyFromURL(u string) string {
+	if i := strings.IndexByte(u, '?'); i >= 0 { u = u[:i] }
+	var key string
+	if base := strings.TrimSuffix(blobs.URL(""), "/"); base != "" && strings.HasPrefix(u, base+"/") {
+		key = strings.TrimPrefix(u, base+"/")
+	} else if i := strings.Index(u, "/uploads/"); i >= 0 && (i == 0 || strings.HasPrefix(u, "http")) {
+		key = u[i+len("/uploads/"):]
+	}
+	if !validBlobKey(key) { return "" }
+	return key
+}
+
+// contentTypeForKey maps the stored extension to a MIME type.
+func contentTypeForKey(key string) string {
+	switch strings.ToLower(filepath.Ext(key)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	}
+	return ""
+}
+
+// --- Local filesystem ---
+
+type localBlobStore struct {
+	root string
+}
+
+func newLocalBlobStore(root string) (*localBlobStore, error) {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil { return nil, err }
+	return &localBlobStore{root: root}, nil
+}
+
+func (s *localBlobStore) path(key string) (string, error) {
+	if !validBlobKey(key) { return "", errBlobNotFound }
+	return filepath.Join(s.root, key), nil
+}
+
+func (s *localBlobStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	path, err := s.path(key)
+	if err != nil { return err }
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil { return err }
+	return os.Rename(tmp, path)
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil { return nil, BlobInfo{}, err }
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) { return nil, BlobInfo{}, errBlobNotFound }
+	if err != nil { return nil, BlobInfo{}, err }
+	info, err := f.Stat()
+	if err != nil || info.IsDir() { f.Close(); return nil, BlobInfo{}, errBlobNotFound }
+	return f, BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *localBlobStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil { return BlobInfo{}, err }
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() { return BlobInfo{}, errBlobNotFound }
+	return BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil { return err }
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) { return err }
+	return nil
+}
+
+func (s *localBlobStore) List(ctx context.Context) ([]BlobInfo, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil { return nil, err }
+	var out []BlobInfo
+	for _, e := range entries {
+		if e.IsDir() || !validBlobKey(e.Name()) || strings.HasSuffix(e.Name(), ".tmp") { continue }
+		info, err := e.Info()
+		if err != nil { continue }
+		out = append(out, BlobInfo{Key: e.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return out, nil
+}
+
+func (s *localBlobStore) URL(key string) string { return "/uploads/" + key }
+
+// SignedURL for local storage points back at /uploads with an HMAC that
+// serveUploadHandler checks via verifyUploadSignature.
+func (s *localBlobStore) SignedURL(key string, ttl time.Duration) (string, error) {
+	if !validBlobKey(key) { return "", errBlobNotFound }
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return fmt.Sprintf("/uploads/%s?expires=%s&sig=%s", key, expires, uploadSignature(key, expires)), nil
+}
+
+func uploadSignature(key, expires string) string {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(key + "|" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyUploadSignature checks the expires/sig pair from a local signed URL.
+// Requests without a signature are allowed since uploads are public.
+func verifyUploadSignature(key string, q url.Values) bool {
+	sig := q.Get("sig")
+	if sig == "" { return true }
+	expires := q.Get("expires")
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp { return false }
+	return hmac.Equal([]byte(sig), []byte(uploadSignature(key, expires)))
+}
+
+// --- S3-compatible (AWS, MinIO, R2...) ---
+
+type s3Config struct {
+	Endpoint  string // e.g. http://localhost:9000 for MinIO
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PublicURL string // Optional CDN/bucket URL; uploads are proxied via /uploads otherwise
+}
+
+// s3BlobStore talks to the S3 REST API using path-style addressing and
+// SigV4 signing, so it works against MinIO and other stand-ins without
+// pulling in the AWS SDK.
+type s3BlobStore struct {
+	cfg    s3Config
+	client *http.Client
+}
+
+func newS3BlobStore(cfg s3Config) (*s3BlobStore, error) {
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" { return nil, errors.New("S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required") }
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	cfg.PublicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+	return &s3BlobStore{cfg: cfg, client: &http.Client{Timeout: 60 * time.Second}}, nil
+}
+
+func (s *s3BlobStore) objectURL(key string) string {
+	return s.cfg.Endpoint + "/" + s3Escape(s.cfg.Bucket) + "/" + s3Escape(key)
+}
+
+func (s *s3BlobStore) do(ctx context.Context, method, rawURL string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil { return nil, err }
+	for k, v := range header { req.Header[k] = v }
+	s.sign(req, body, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+func (s *s3BlobStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if !validBlobKey(key) { return errBlobNotFound }
+	h := http.Header{}
+	if contentType != "" { h.Set("Content-Type", contentType) }
+	resp, err := s.do(ctx, "PUT", s.objectURL(key), data, h)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK { return s3Error(resp) }
+	return nil
+}
+
+func (s *s3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error) {
+	if !validBlobKey(key) { return nil, BlobInfo{}, errBlobNotFound }
+	resp, err := s.do(ctx, "GET", s.objectURL(key), nil, nil)
+	if err != nil { return nil, BlobInfo{}, err }
+	if resp.StatusCode == http.StatusNotFound { resp.Body.Close(); return nil, BlobInfo{}, errBlobNotFound }
+	if resp.StatusCode != http.StatusOK { defer resp.Body.Close(); return nil, BlobInfo{}, s3Error(resp) }
+	return resp.Body, s3Info(key, resp), nil
+}
+
+func (s *s3BlobStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	if !validBlobKey(key) { return BlobInfo{}, errBlobNotFound }
+	resp, err := s.do(ctx, "HEAD", s.objectURL(key), nil, nil)
+	if err != nil { return BlobInfo{}, err }
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound { return BlobInfo{}, errBlobNotFound }
+	if resp.StatusCode != http.StatusOK { return BlobInfo{}, fmt.Errorf("s3: HEAD %s: %s", key, resp.Status) }
+	return s3Info(key, resp), nil
+}
+
+func (s *s3BlobStore) Delete(ctx context.Context, key string) error {
+	if !validBlobKey(key) { return errBlobNotFound }
+	resp, err := s.do(ctx, "DELETE", s.objectURL(key), nil, nil)
+	if err != nil { return err }
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK { return s3Error(resp) }
+	return nil
+}
+
+func (s *s3BlobStore) List(ctx context.Context) ([]BlobInfo, error) {
+	var out []BlobInfo
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}}
+		if token != "" { q.Set("continuation-token", token) }
+		resp, err := s.do(ctx, "GET", s.cfg.Endpoint+"/"+s3Escape(s.cfg.Bucket)+"?"+s3Query(q), nil, nil)
+		if err != nil { return nil, err }
+		if resp.StatusCode != http.StatusOK { defer resp.Body.Close(); return nil, s3Error(resp) }
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil { return nil, err }
+		for _, c := range result.Contents {
+			out = append(out, BlobInfo{Key: c.Key, Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" { return out, nil }
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3BlobStore) URL(key string) string {
+	if s.cfg.PublicURL != "" { return s.cfg.PublicURL + "/" + key }
+	return "/uploads/" + key
+}
+
+// SignedURL returns a SigV4 presigned GET URL.
+func (s *s3BlobStore) SignedURL(key string, ttl time.Duration) (string, error) {
+	if !validBlobKey(key) { return "", errBlobNotFound }
+	now := time.Now().UTC()
+	u, err := url.Parse(s.objectURL(key))
+	if err != nil { return "", err }
+	q := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.cfg.AccessKey + "/" + s.scope(now)},
+		"X-Amz-Date":          {now.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	canonical := strings.Join([]string{"GET", u.EscapedPath(), s3Query(q), "host:" + u.Host + "\n", "host", "UNSIGNED-PAYLOAD"}, "\n")
+	q.Set("X-Amz-Signature", s.signature(now, canonical))
+	u.RawQuery = s3Query(q)
+	return u.String(), nil
+}
+
+func (s *s3BlobStore) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// sign adds SigV4 Authorization headers to req.
+func (s *s3BlobStore) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "content-type" || strings.HasPrefix(lk, "x-amz-") {
+			names = append(names, lk)
+			values[lk] = strings.TrimSpace(req.Header.Get(k))
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, n := range names {
+		canonicalHeaders.WriteString(n + ":" + values[n] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), s3Query(req.URL.Query()), canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, s.scope(now), signedHeaders, s.signature(now, canonical)))
+}
+
+func (s *s3BlobStore) signature(now time.Time, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + s.scope(now) + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape percent-encodes a path segment the way SigV4 expects.
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "+", "%2B")
+}
+
+// s3Query encodes query parameters sorted by key with %20 for spaces.
+func s3Query(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+func s3Info(key string, resp *http.Response) BlobInfo {
+	info := BlobInfo{Key: key, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil { info.ModTime = t }
+	return info
+}
+
+func s3Error(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("s3: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
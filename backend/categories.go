@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// --- Categories ---
+//
+// Place categories live in the categories table; places.category holds
+// the slug. The taxonomy is small and read on every place write, so it is
+// kept in memory and reloaded after each admin change.
+
+//go:embed seed/categories.json
+var categoriesSeedJSON []byte
+
+const defaultCategory = "other" // Takes over places of a deleted category by default
+
+type Category struct {
+	Slug         string            `json:"slug"`
+	Labels       map[string]string `json:"labels"`
+	Icon         string            `json:"icon"`
+	Parent       string            `json:"parent,omitempty"`
+	VisitMinutes int               `json:"visit_minutes"` // Default dwell time for the planner
+	Position     int               `json:"position"`
+}
+
+type categorySet struct {
+	list   []Category
+	bySlug map[string]Category
+	lookup map[string]string // Folded slug or label -> slug
+}
+
+var taxonomy atomic.Pointer[categorySet]
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+var slugInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a label like "Antik Kent" into "antik-kent".
+func slugify(s string) string {
+	return strings.Trim(slugInvalid.ReplaceAllString(foldName(s), "-"), "-")
+}
+
+func initCategoryTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS categories (
+		slug TEXT PRIMARY KEY,
+		labels JSONB NOT NULL DEFAULT '{}',
+		icon TEXT DEFAULT '',
+		parent TEXT REFERENCES categories(slug) ON DELETE SET NULL,
+		visit_minutes INT NOT NULL DEFAULT 45,
+		position INT NOT NULL DEFAULT 0
+	)`)
+	var seed []Category
+	if err := json.Unmarshal(categoriesSeedJSON, &seed); err != nil { log.Fatalf("Category seed: %v", err) }
+	for _, c := range seed {
+		labels, _ := json.Marshal(c.Labels)
+		db.Exec("INSERT INTO categories (slug, labels, icon, parent, visit_minutes, position) VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6) ON CONFLICT (slug) DO NOTHING",
+			c.Slug, string(labels), c.Icon, c.Parent, c.VisitMinutes, c.Position)
+	}
+	if err := reloadCategories(); err != nil { log.Fatalf("Categories: %v", err) }
+	migrateCategoryNames()
+}
+
+func reloadCategories() error {
+	rows, err := db.Query("SELECT slug, labels, COALESCE(icon, ''), COALESCE(parent, ''), visit_minutes, position FROM categories ORDER BY position, slug")
+	if err != nil { return err }
+	defer rows.Close()
+	set := &categorySet{list: []Category{}, bySlug: map[string]Category{}, lookup: map[string]string{}}
+	for rows.Next() {
+		var c Category
+		var labelsJSON []byte
+		if err := rows.Scan(&c.Slug, &labelsJSON, &c.Icon, &c.Parent, &c.VisitMinutes, &c.Position); err != nil { return err }
+		json.Unmarshal(labelsJSON, &c.Labels)
+		set.list = append(set.list, c)
+		set.bySlug[c.Slug] = c
+	}
+	// Slugs go in last so a label can never shadow another category's slug
+	for _, c := range set.list {
+		for _, l := range c.Labels {
+			if _, taken := set.lookup[foldName(l)]; !taken { set.lookup[foldName(l)] = c.Slug }
+		}
+	}
+	for _, c := range set.list { set.lookup[c.Slug] = c.Slug }
+	taxonomy.Store(set)
+	return rows.Err()
+}
+
+// resolveCategory accepts a slug or any localized label (so legacy values
+// like "Antik Kent" keep working) and returns the slug.
+func resolveCategory(s string) (string, bool) {
+	set := taxonomy.Load()
+	if set == nil { return "", false }
+	if _, ok := set.bySlug[s]; ok { return s, true }
+	slug, ok := set.lookup[foldName(s)]
+	return slug, ok
+}
+
+// categoryWithDescendants expands slugs to include every subcategory.
+func categoryWithDescendants(slugs []string) []string {
+	set := taxonomy.Load()
+	seen := map[string]bool{}
+	var out []string
+	for len(slugs) > 0 {
+		s := slugs[0]
+		slugs = slugs[1:]
+		if seen[s] { continue }
+		seen[s] = true
+		out = append(out, s)
+		for _, c := range set.list {
+			if c.Parent == s { slugs = append(slugs, c.Slug) }
+		}
+	}
+	return out
+}
+
+// categoryVisitMinutes is the planner's dwell time for a category.
+func categoryVisitMinutes(slug string) (int, bool) {
+	c, ok := taxonomy.Load().bySlug[slug]
+	return c.VisitMinutes, ok && c.VisitMinutes > 0
+}
+
+// migrateCategoryNames rewrites free-text categories from before the
+// table existed to slugs. Strings matching no label become categories of
+// their own rather than being lumped into "other".
+func migrateCategoryNames() {
+	db.Exec("UPDATE places SET category = $1 WHERE category IS NULL OR category = ''", defaultCategory)
+	rows, err := db.Query("SELECT DISTINCT category FROM places WHERE category NOT IN (SELECT slug FROM categories)")
+	if err != nil { log.Printf("Category migration: %v", err); return }
+	var legacy []string
+	for rows.Next() {
+		var s string
+		rows.Scan(&s)
+		legacy = append(legacy, s)
+	}
+	rows.Close()
+	created := false
+	for _, s := range legacy {
+		slug, ok := resolveCategory(s)
+		if !ok {
+			if slug = slugify(s); slug == "" { slug = defaultCategory }
+			labels, _ := json.Marshal(map[string]string{"tr": s})
+			db.Exec("INSERT INTO categories (slug, labels, position) VALUES ($1, $2, 1000) ON CONFLICT (slug) DO NOTHING", slug, string(labels))
+			created = true
+		}
+		res, err := db.Exec("UPDATE places SET category = $1 WHERE category = $2", slug, s)
+		if err != nil { log.Printf("Category migration %q: %v", s, err); continue }
+		n, _ := res.RowsAffected()
+		log.Printf("Category migration: %q -> %s (%d places)", s, slug, n)
+	}
+	if created { reloadCategories() }
+}
+
+// validateCategory checks an admin create/update; parents must exist and
+// may not make the tree cyclic.
+func validateCategory(c Category) string {
+	if !slugPattern.MatchString(c.Slug) || len(c.Slug) > 50 { return "slug must be lowercase letters, digits and dashes" }
+	if len(c.Labels) == 0 { return "at least one label is required" }
+	for lang, l := range c.Labels {
+		if strings.TrimSpace(l) == "" || len(l) > 100 || len(lang) > 10 { return "invalid label" }
+	}
+	if c.VisitMinutes < 0 || c.VisitMinutes > 24*60 { return "visit_minutes must be between 0 and 1440" }
+	set := taxonomy.Load()
+	for p := c.Parent; p != ""; p = set.bySlug[p].Parent {
+		if p == c.Slug { return "parent would create a cycle" }
+		if _, ok := set.bySlug[p]; !ok { return "unknown parent" }
+	}
+	return ""
+}
+
+// categoriesHandler serves GET /api/categories to everyone; admins can
+// POST a new category, PUT ?slug= to update one and DELETE ?slug= (with
+// optional &replace=, default "other") to remove one, moving its places.
+func categoriesHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method == "GET" {
+		json.NewEncoder(w).Encode(taxonomy.Load().list)
+		return
+	}
+	adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		slug := r.URL.Query().Get("slug")
+		switch r.Method {
+		case "POST", "PUT":
+			var c Category
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil { http.Error(w, "Invalid body", http.StatusBadRequest); return }
+			if r.Method == "PUT" {
+				if _, ok := taxonomy.Load().bySlug[slug]; !ok { http.Error(w, "Category not found", http.StatusNotFound); return }
+				c.Slug = slug
+			}
+			if c.VisitMinutes == 0 { c.VisitMinutes = defaultVisitMinute }
+			if msg := validateCategory(c); msg != "" { http.Error(w, msg, http.StatusBadRequest); return }
+			labels, _ := json.Marshal(c.Labels)
+			var err error
+			if r.Method == "POST" {
+				var res sql.Result
+				res, err = db.Exec("INSERT INTO categories (slug, labels, icon, parent, visit_minutes, position) VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6) ON CONFLICT (slug) DO NOTHING",
+					c.Slug, string(labels), c.Icon, c.Parent, c.VisitMinutes, c.Position)
+				if err == nil {
+					if n, _ := res.RowsAffected(); n == 0 { http.Error(w, "Slug already exists", http.StatusConflict); return }
+				}
+			} else {
+				_, err = db.Exec("UPDATE categories SET labels = $2, icon = $3, parent = NULLIF($4, ''), visit_minutes = $5, position = $6 WHERE slug = $1",
+					c.Slug, string(labels), c.Icon, c.Parent, c.VisitMinutes, c.Position)
+			}
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			reloadCategories()
+			if r.Method == "POST" { w.WriteHeader(http.StatusCreated) }
+			json.NewEncoder(w).Encode(taxonomy.Load().bySlug[c.Slug])
+		case "DELETE":
+			replace := r.URL.Query().Get("replace")
+			if replace == "" { replace = defaultCategory }
+			set := taxonomy.Load()
+			if _, ok := set.bySlug[slug]; !ok { http.Error(w, "Category not found", http.StatusNotFound); return }
+			if _, ok := set.bySlug[replace]; !ok || replace == slug { http.Error(w, "Invalid replacement category", http.StatusBadRequest); return }
+			var moved int64
+			err := withTx(func(tx *sql.Tx) error {
+				res, err := tx.Exec("UPDATE places SET category = $1 WHERE category = $2", replace, slug)
+				if err != nil { return err }
+				moved, _ = res.RowsAffected()
+				_, err = tx.Exec("DELETE FROM categories WHERE slug = $1", slug)
+				return err
+			})
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			reloadCategories()
+			json.NewEncoder(w).Encode(map[string]interface{}{"deleted": slug, "replacement": replace, "places_moved": moved})
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})(w, r)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// --- Check-ins ---
+
+var checkinMaxDistanceM = envFloat("CHECKIN_MAX_DISTANCE_M", 250)
+var checkinCooldown = envDuration("CHECKIN_COOLDOWN", 24*time.Hour) // Per user and place
+var checkinHourlyLimit = envInt("CHECKIN_HOURLY_LIMIT", 20)          // Per user, across places
+
+const eventPlaceVisited = "place_visited"
+
+var errCheckinCooldown = errors.New("checkin cooldown")
+var errCheckinRateLimit = errors.New("checkin rate limit")
+
+type Checkin struct {
+	ID        int       `json:"id"`
+	PlaceID   int       `json:"place_id"`
+	Lat       float64   `json:"lat"`
+	Lng       float64   `json:"lng"`
+	DistanceM float64   `json:"distance_m"`
+	CreatedAt time.Time `json:"created_at"`
+	Points    int       `json:"points_awarded"`
+}
+
+// VisitedPlace is a place the user has checked in to at least once.
+type VisitedPlace struct {
+	Place
+	Visits       int       `json:"visits"`
+	FirstVisitAt time.Time `json:"first_visit_at"`
+	LastVisitAt  time.Time `json:"last_visit_at"`
+}
+
+// PassportStamp counts visited vs. available places for a city or category.
+type PassportStamp struct {
+	Name    string `json:"name"`
+	Visited int    `json:"visited"`
+	Total   int    `json:"total"`
+}
+
+type Passport struct {
+	TotalVisited int             `json:"total_visited"`
+	Cities       []PassportStamp `json:"cities"`
+	Categories   []PassportStamp `json:"categories"`
+}
+
+func envFloat(key string, fallback float64) float64 {
+	if f, err := strconv.ParseFloat(getEnv(key, ""), 64); err == nil { return f }
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	if i, err := strconv.Atoi(getEnv(key, "")); err == nil { return i }
+	return fallback
+}
+
+func initCheckinTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS checkins (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		place_id INT NOT NULL REFERENCES places(id) ON DELETE CASCADE,
+		lat DOUBLE PRECISION,
+		lng DOUBLE PRECISION,
+		distance_m DOUBLE PRECISION,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS checkins_user_place ON checkins (user_id, place_id, created_at)")
+	seedPointRule(PointRule{EventType: eventPlaceVisited, Points: 20, Description: "First check-in at a place", DailyCap: 20})
+}
+
+// createCheckin validates and records a check-in. The first visit to each
+// place earns points; later visits are recorded but not rewarded.
+func createCheckin(userID int, c *Checkin) (int, string) {
+	if !validCoords(c.Lat, c.Lng) { return http.StatusBadRequest, "Invalid coordinates" }
+	c.PlaceID = resolvePlaceID(c.PlaceID)
+	var placeLat, placeLng float64
+	err := db.QueryRow("SELECT lat, lng FROM places WHERE id = $1 AND status = 'approved'", c.PlaceID).Scan(&placeLat, &placeLng)
+	if err != nil { return http.StatusNotFound, "Place not found" }
+	c.DistanceM = haversineKm(c.Lat, c.Lng, placeLat, placeLng) * 1000
+	if c.DistanceM > checkinMaxDistanceM { return http.StatusUnprocessableEntity, "You are too far from this place to check in" }
+
+	err = withTx(func(tx *sql.Tx) error {
+		// Serialise a user's check-ins so the limits below can't be raced
+		if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", "checkin:"+strconv.Itoa(userID)); err != nil { return err }
+		var recent, lastHour int
+		tx.QueryRow("SELECT COUNT(*) FROM checkins WHERE user_id = $1 AND place_id = $2 AND created_at > $3", userID, c.PlaceID, time.Now().Add(-checkinCooldown)).Scan(&recent)
+		if recent > 0 { return errCheckinCooldown }
+		tx.QueryRow("SELECT COUNT(*) FROM checkins WHERE user_id = $1 AND created_at > $2", userID, time.Now().Add(-time.Hour)).Scan(&lastHour)
+		if lastHour >= checkinHourlyLimit { return errCheckinRateLimit }
+		if err := tx.QueryRow("INSERT INTO checkins (user_id, place_id, lat, lng, distance_m) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at", userID, c.PlaceID, c.Lat, c.Lng, c.DistanceM).Scan(&c.ID, &c.CreatedAt); err != nil { return err }
+		ledgerID, err := awardPoints(tx, userID, eventPlaceVisited, refPlace, c.PlaceID)
+		if err != nil || ledgerID == 0 { return err }
+		return tx.QueryRow("SELECT points FROM points_ledger WHERE id = $1", ledgerID).Scan(&c.Points)
+	})
+	switch err {
+	case nil:
+		return http.StatusCreated, ""
+	case errCheckinCooldown:
+		return http.StatusTooManyRequests, "Already checked in here recently"
+	case errCheckinRateLimit:
+		return http.StatusTooManyRequests, "Too many check-ins, try again later"
+	}
+	return http.StatusInternalServerError, "Database error"
+}
+
+func visitedPlaces(userID int) ([]VisitedPlace, error) {
+	rows, err := db.Query(`
+		SELECT p.id, p.name, p.description, p.lat, p.lng, p.category, p.city, COALESCE(p.image_url, ''), p.status,
+			COUNT(c.id), MIN(c.created_at), MAX(c.created_at)
+		FROM checkins c JOIN places p ON p.id = c.place_id
+		WHERE c.user_id = $1
+		GROUP BY p.id ORDER BY MAX(c.created_at) DESC`, userID)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	visited := []VisitedPlace{}
+	for rows.Next() {
+		var v VisitedPlace
+		var nameJSON, descJSON []byte
+		rows.Scan(&v.ID, &nameJSON, &descJSON, &v.Lat, &v.Lng, &v.Category, &v.City, &v.ImageURL, &v.Status, &v.Visits, &v.FirstVisitAt, &v.LastVisitAt)
+		json.Unmarshal(nameJSON, &v.Name)
+		json.Unmarshal(descJSON, &v.Description)
+		v.Images = imageVariants(v.ImageURL)
+		visited = append(visited, v)
+	}
+	return visited, nil
+}
+
+// passportStamps groups approved places by column and counts how many the
+// user has visited.
+func passportStamps(userID int, column string) ([]PassportStamp, error) {
+	rows, err := db.Query(`
+		SELECT p.`+column+`, COUNT(DISTINCT c.place_id), COUNT(DISTINCT p.id)
+		FROM places p LEFT JOIN checkins c ON c.place_id = p.id AND c.user_id = $1
+		WHERE p.status = 'approved' AND COALESCE(p.`+column+`, '') <> ''
+		GROUP BY p.`+column+`
+		ORDER BY COUNT(DISTINCT c.place_id) DESC, p.`+column, userID)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	stamps := []PassportStamp{}
+	for rows.Next() {
+		var s PassportStamp
+		rows.Scan(&s.Name, &s.Visited, &s.Total)
+		stamps = append(stamps, s)
+	}
+	return stamps, nil
+}
+
+// checkinsHandler serves /api/checkins. POST checks in to a place, GET
+// lists visited places, GET ?action=passport summarises them by city and
+// category. The passport accepts ?user_id= to view someone else's; the
+// visit list is location history and only its owner or an admin sees it.
+func checkinsHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	userID, claims := currentUser(r)
+	if r.Method == "POST" {
+		if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+		var c Checkin
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil { http.Error(w, "Invalid request", http.StatusBadRequest); return }
+		status, msg := createCheckin(userID, &c)
+		if msg != "" { http.Error(w, msg, status); return }
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(c)
+		return
+	}
+	if r.Method != "GET" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	passport := r.URL.Query().Get("action") == "passport"
+	if idStr := r.URL.Query().Get("user_id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil { http.Error(w, "Invalid user ID", http.StatusBadRequest); return }
+		if id != userID && !passport && (claims == nil || claims.Role != "admin") { http.Error(w, "Forbidden", http.StatusForbidden); return }
+		userID = id
+	}
+	if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+	if passport {
+		var p Passport
+		var err error
+		db.QueryRow("SELECT COUNT(DISTINCT place_id) FROM checkins WHERE user_id = $1", userID).Scan(&p.TotalVisited)
+		if p.Cities, err = passportStamps(userID, "city"); err == nil { p.Categories, err = passportStamps(userID, "category") }
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		json.NewEncoder(w).Encode(p)
+		return
+	}
+	visited, err := visitedPlaces(userID)
+	if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+	json.NewEncoder(w).Encode(visited)
+}
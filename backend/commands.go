@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Maintenance commands ---
+//
+// Run as `go run . <command> [flags]` (or `./backend <command>`) against
+// the same environment as the server.
+
+var commands = map[string]func(args []string) error{
+	"migrate-blobs":    migrateBlobsCommand,
+	"route":            routeCommand,
+	"geocode-backfill": geocodeBackfillCommand,
+	"fetch-boundaries": fetchBoundariesCommand,
+}
+
+func runCommand(name string, args []string) {
+	cmd, ok := commands[name]
+	if !ok {
+		var names []string
+		for n := range commands { names = append(names, n) }
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "unknown command %q, available: %s\n", name, strings.Join(names, ", "))
+		os.Exit(2)
+	}
+	if err := cmd(args); err != nil { log.Fatalf("%s: %v", name, err) }
+}
+
+// migrateBlobsCommand copies files from a local uploads directory into the
+// store configured by BLOB_BACKEND, rewrites image_url/avatar_url to the
+// new store's URLs, then removes the local copies.
+func migrateBlobsCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate-blobs", flag.ExitOnError)
+	from := fs.String("from", uploadDir, "local directory to migrate from")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	keepSource := fs.Bool("keep-source", false, "leave files in the source directory")
+	fs.Parse(args)
+
+	src, err := newLocalBlobStore(*from)
+	if err != nil { return err }
+	if blobs, err = newBlobStore(); err != nil { return err }
+	if dst, ok := blobs.(*localBlobStore); ok && dst.root == src.root { return fmt.Errorf("source and destination are both %s", src.root) }
+	initDB()
+
+	ctx := context.Background()
+	files, err := src.List(ctx)
+	if err != nil { return err }
+	copied := 0
+	for _, f := range files {
+		if info, err := blobs.Stat(ctx, f.Key); err == nil && info.Size == f.Size { continue }
+		copied++
+		if *dryRun { log.Printf("would copy %s (%d bytes)", f.Key, f.Size); continue }
+		rc, _, err := src.Get(ctx, f.Key)
+		if err != nil { return err }
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil { return err }
+		if err := blobs.Put(ctx, f.Key, data, contentTypeForKey(f.Key)); err != nil { return fmt.Errorf("copy %s: %w", f.Key, err) }
+	}
+	log.Printf("%d of %d files copied", copied, len(files))
+
+	tx, err := db.Begin()
+	if err != nil { return err }
+	defer tx.Rollback()
+	rewritten := 0
+	for _, ref := range []struct{ table, column string }{{"places", "image_url"}, {"users", "avatar_url"}} {
+		rows, err := tx.Query(fmt.Sprintf("SELECT id, %s FROM %s WHERE COALESCE(%s, '') <> ''", ref.column, ref.table, ref.column))
+		if err != nil { return err }
+		updates := map[int]string{}
+		for rows.Next() {
+			var id int
+			var u string
+			rows.Scan(&id, &u)
+			key := blobKeyFromURL(u)
+			if key == "" { continue }
+			if newURL := blobs.URL(key); newURL != u { updates[id] = newURL }
+		}
+		rows.Close()
+		for id, newURL := range updates {
+			rewritten++
+			if *dryRun { log.Printf("would set %s.%s = %s for id %d", ref.table, ref.column, newURL, id); continue }
+			if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = $1 WHERE id = $2", ref.table, ref.column), newURL, id); err != nil { return err }
+		}
+	}
+	log.Printf("%d references rewritten", rewritten)
+	if *dryRun { return nil }
+	if err := tx.Commit(); err != nil { return err }
+
+	if !*keepSource {
+		for _, f := range files {
+			if err := src.Delete(ctx, f.Key); err != nil { log.Printf("could not remove %s: %v", f.Key, err) }
+		}
+	}
+	return nil
+}
+
+// routeCommand loads a PBF extract and prints the travel estimate between
+// two points, for checking an extract before pointing ROUTING_PBF at it.
+func routeCommand(args []string) error {
+	fs := flag.NewFlagSet("route", flag.ExitOnError)
+	pbf := fs.String("pbf", routingPBF, "OSM .osm.pbf extract")
+	profile := fs.String("profile", profileCar, "car or walk")
+	fs.Parse(args)
+	if fs.NArg() != 4 || !validProfile(*profile) { return fmt.Errorf("usage: route [-pbf file] [-profile car|walk] <lat1> <lng1> <lat2> <lng2>") }
+	var c [4]float64
+	for i := range c {
+		if _, err := fmt.Sscan(fs.Arg(i), &c[i]); err != nil { return fmt.Errorf("invalid coordinate %q", fs.Arg(i)) }
+	}
+	g, err := loadRoadGraph(*pbf)
+	if err != nil { return err }
+	log.Printf("loaded %d vertices, %d edges", len(g.lat), len(g.to))
+	res := g.travel(*profile, c[0], c[1], c[2], c[3])
+	if !res.ok { return fmt.Errorf("no route found") }
+	fmt.Printf("%.1f km, %.0f min by %s (straight line %.1f km)\n", res.km, res.minutes, *profile, haversineKm(c[0], c[1], c[2], c[3]))
+	return nil
+}
+
+// geocodeBackfillCommand recomputes city and district for existing places.
+func geocodeBackfillCommand(args []string) error {
+	fs := flag.NewFlagSet("geocode-backfill", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	fs.Parse(args)
+	if err := loadGazetteer(); err != nil { return err }
+	if g := geo.Load(); len(g.provAreas) == 0 && len(g.districts) == 0 { return fmt.Errorf("no boundary polygons loaded; run fetch-boundaries and rebuild, or set GEO_PROVINCES_GEOJSON") }
+	initDB()
+
+	rows, err := db.Query("SELECT id, lat, lng, COALESCE(city, ''), COALESCE(district, '') FROM places ORDER BY id")
+	if err != nil { return err }
+	type change struct {
+		id             int
+		city, district string
+	}
+	var changes []change
+	total := 0
+	for rows.Next() {
+		var id int
+		var lat, lng float64
+		var city, district string
+		if err := rows.Scan(&id, &lat, &lng, &city, &district); err != nil { rows.Close(); return err }
+		total++
+		newCity, newDistrict := resolveCity(lat, lng, city)
+		if newDistrict == "" { newDistrict = district }
+		if newCity == city && newDistrict == district { continue }
+		if *dryRun { log.Printf("place %d: %q/%q -> %q/%q", id, city, district, newCity, newDistrict) }
+		changes = append(changes, change{id, newCity, newDistrict})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil { return err }
+	log.Printf("%d of %d places would change", len(changes), total)
+	if *dryRun { return nil }
+	for _, c := range changes {
+		if _, err := db.Exec("UPDATE places SET city = $1, district = NULLIF($2, '') WHERE id = $3", c.city, c.district, c.id); err != nil { return err }
+	}
+	log.Printf("%d places updated", len(changes))
+	return nil
+}
+
+// fetchBoundariesCommand downloads the simplified geoBoundaries TUR ADM1
+// and ADM2 polygons into geodata/, where the next build embeds them.
+func fetchBoundariesCommand(args []string) error {
+	fs := flag.NewFlagSet("fetch-boundaries", flag.ExitOnError)
+	api := fs.String("api", "https://www.geoboundaries.org/api/current/gbOpen/TUR", "geoBoundaries API base for the country")
+	dir := fs.String("dir", "geodata", "directory to write tr-adm1.geojson and tr-adm2.geojson to")
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	get := func(url string) (*http.Response, error) {
+		resp, err := client.Get(url)
+		if err != nil { return nil, err }
+		if resp.StatusCode != http.StatusOK { resp.Body.Close(); return nil, fmt.Errorf("%s: %s", url, resp.Status) }
+		return resp, nil
+	}
+	for _, level := range []string{"ADM1", "ADM2"} {
+		resp, err := get(*api + "/" + level + "/")
+		if err != nil { return err }
+		var meta struct {
+			Simplified string `json:"simplifiedGeometryGeoJSON"`
+			License    string `json:"boundaryLicense"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&meta)
+		resp.Body.Close()
+		if err != nil { return fmt.Errorf("%s metadata: %w", level, err) }
+		if meta.Simplified == "" { return fmt.Errorf("%s: no simplified GeoJSON listed", level) }
+
+		if resp, err = get(meta.Simplified); err != nil { return err }
+		path := filepath.Join(*dir, "tr-"+strings.ToLower(level)+".geojson")
+		out, err := os.Create(path)
+		if err != nil { resp.Body.Close(); return err }
+		n, err := io.Copy(out, resp.Body)
+		resp.Body.Close()
+		if cerr := out.Close(); err == nil { err = cerr }
+		if err != nil { return err }
+		log.Printf("%s: %d bytes from %s (%s)", path, n, meta.Simplified, meta.License)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Duplicate places ---
+//
+// New places are compared against existing ones nearby: a candidate is
+// within duplicateRadiusM and its name, in any language key, is similar
+// enough. POST /api/places answers 409 with the candidates (approved ones
+// only, unless the caller is an admin) unless the client resends with
+// ?force=1. Admins can then merge a duplicate into the
+// surviving place; the old ID keeps resolving through place_redirects.
+
+var duplicateRadiusM = envFloat("DUPLICATE_RADIUS_M", 250)
+var duplicateMinSimilarity = envFloat("DUPLICATE_MIN_SIMILARITY", 0.6)
+
+const duplicateNearM = 30 // This close, half the usual similarity is enough
+
+var errMergeSelf = errors.New("cannot merge a place into itself")
+
+type DuplicateCandidate struct {
+	PlaceID    int               `json:"place_id"`
+	Name       map[string]string `json:"name"`
+	Status     string            `json:"status"`
+	DistanceM  float64           `json:"distance_m"`
+	Similarity float64           `json:"similarity"`
+}
+
+func initDuplicateTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS place_redirects (
+		old_id INT PRIMARY KEY,
+		new_id INT NOT NULL REFERENCES places(id) ON DELETE CASCADE,
+		merged_by INT REFERENCES users(id) ON DELETE SET NULL,
+		merged_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+}
+
+// levenshtein counts single-rune edits between a and b.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev { prev[j] = j }
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] { cost = 0 }
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// stringSimilarity is 0..1: the better of edit-distance similarity (typos,
+// suffixes like Kent/Kenti) and word overlap (reordered words).
+func stringSimilarity(a, b string) float64 {
+	a, b = foldName(a), foldName(b)
+	if a == "" || b == "" { return 0 }
+	if a == b { return 1 }
+	ra, rb := []rune(a), []rune(b)
+	edit := 1 - float64(levenshtein(ra, rb))/float64(max(len(ra), len(rb)))
+	wa, wb := strings.Fields(a), strings.Fields(b)
+	seen := map[string]bool{}
+	for _, w := range wa { seen[w] = true }
+	common := 0
+	for _, w := range wb {
+		if seen[w] { common++; delete(seen, w) }
+	}
+	dice := 2 * float64(common) / float64(len(wa)+len(wb))
+	return math.Max(edit, dice)
+}
+
+// nameSimilarity compares every language key of a with every key of b.
+func nameSimilarity(a, b map[string]string) float64 {
+	best := 0.0
+	for _, x := range a {
+		for _, y := range b {
+			if s := stringSimilarity(x, y); s > best { best = s }
+		}
+	}
+	return best
+}
+
+// findDuplicatePlaces returns places near lat/lng with a similar name,
+// most similar first. excludeID skips the place itself.
+func findDuplicatePlaces(lat, lng float64, name map[string]string, excludeID int) ([]DuplicateCandidate, error) {
+	dLat := duplicateRadiusM / 111000
+	dLng := dLat / math.Max(0.01, math.Cos(lat*math.Pi/180))
+	// Rejected and archived places don't count; they may well be the reason for a resubmission
+	rows, err := db.Query("SELECT id, name, lat, lng, COALESCE(status, '') FROM places WHERE id <> $1 AND lat BETWEEN $2 AND $3 AND lng BETWEEN $4 AND $5 AND COALESCE(status, '') NOT IN ($6, $7)",
+		excludeID, lat-dLat, lat+dLat, lng-dLng, lng+dLng, statusRejected, statusArchived)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	candidates := []DuplicateCandidate{}
+	for rows.Next() {
+		var c DuplicateCandidate
+		var nameJSON []byte
+		var pLat, pLng float64
+		rows.Scan(&c.PlaceID, &nameJSON, &pLat, &pLng, &c.Status)
+		json.Unmarshal(nameJSON, &c.Name)
+		c.DistanceM = haversineKm(lat, lng, pLat, pLng) * 1000
+		if c.DistanceM > duplicateRadiusM { continue }
+		c.Similarity = nameSimilarity(name, c.Name)
+		required := duplicateMinSimilarity
+		if c.DistanceM <= duplicateNearM { required /= 2 }
+		if c.Similarity < required { continue }
+		c.DistanceM = math.Round(c.DistanceM)
+		c.Similarity = math.Round(c.Similarity*100) / 100
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Similarity != candidates[j].Similarity { return candidates[i].Similarity > candidates[j].Similarity }
+		return candidates[i].DistanceM < candidates[j].DistanceM
+	})
+	return candidates, rows.Err()
+}
+
+// visibleDuplicates drops candidates that aren't approved unless the
+// caller is an admin, so pending submissions stay private.
+func visibleDuplicates(candidates []DuplicateCandidate, isAdmin bool) []DuplicateCandidate {
+	if isAdmin { return candidates }
+	visible := candidates[:0]
+	for _, c := range candidates {
+		if c.Status == "approved" { visible = append(visible, c) }
+	}
+	return visible
+}
+
+// resolvePlaceID follows merge redirects; IDs that were never merged come
+// back unchanged.
+func resolvePlaceID(id int) int {
+	var newID int
+	if err := db.QueryRow("SELECT new_id FROM place_redirects WHERE old_id = $1", id).Scan(&newID); err == nil { return newID }
+	return id
+}
+
+// mergePlaces folds source into target: comments, favorites, check-ins,
+// list and route entries, points attribution and (if target has none) the
+// photo move over, then source is deleted and redirected. It returns the
+// source image URL when that reference was dropped.
+func mergePlaces(tx *sql.Tx, sourceID, targetID, adminID int) (string, error) {
+	if sourceID == targetID { return "", errMergeSelf }
+	var sourceImage, targetImage, sourceStatus string
+	if err := tx.QueryRow("SELECT COALESCE(image_url, ''), COALESCE(status, '') FROM places WHERE id = $1 FOR UPDATE", sourceID).Scan(&sourceImage, &sourceStatus); err != nil { return "", err }
+	if err := tx.QueryRow("SELECT COALESCE(image_url, '') FROM places WHERE id = $1 FOR UPDATE", targetID).Scan(&targetImage); err != nil { return "", err }
+
+	steps := []string{
+		"UPDATE comments SET place_id = $2 WHERE place_id = $1",
+		"INSERT INTO favorites (user_id, place_id) SELECT user_id, $2 FROM favorites WHERE place_id = $1 ON CONFLICT DO NOTHING",
+		"UPDATE checkins SET place_id = $2 WHERE place_id = $1",
+		"UPDATE list_entries SET place_id = $2 WHERE place_id = $1 AND list_id NOT IN (SELECT list_id FROM list_entries WHERE place_id = $2)",
+		"UPDATE route_stops SET place_id = $2 WHERE place_id = $1",
+		"UPDATE place_edits SET place_id = $2 WHERE place_id = $1 AND status = 'pending'",
+		"UPDATE points_ledger SET ref_id = $2 WHERE ref_type = '" + refPlace + "' AND ref_id = $1",
+		"UPDATE place_redirects SET new_id = $2 WHERE new_id = $1",
+	}
+	for _, q := range steps {
+		if _, err := tx.Exec(q, sourceID, targetID); err != nil { return "", fmt.Errorf("merge: %w", err) }
+	}
+	// An approved duplicate keeps the survivor visible
+	if sourceStatus == "approved" {
+		if _, err := tx.Exec("UPDATE places SET status = 'approved' WHERE id = $1", targetID); err != nil { return "", err }
+	}
+	released := sourceImage
+	if targetImage == "" && sourceImage != "" {
+		if _, err := tx.Exec("UPDATE places SET image_url = $1 WHERE id = $2", sourceImage, targetID); err != nil { return "", err }
+		released = ""
+	}
+	if _, err := tx.Exec("DELETE FROM places WHERE id = $1", sourceID); err != nil { return "", err }
+	if _, err := tx.Exec("INSERT INTO place_redirects (old_id, new_id, merged_by) VALUES ($1, $2, NULLIF($3, 0))", sourceID, targetID, adminID); err != nil { return "", err }
+	return released, nil
+}
+
+// duplicatesAdmin handles the admin actions: GET duplicates (&id= for one
+// place, otherwise every pending place with candidates) and POST merge.
+func duplicatesAdmin(w http.ResponseWriter, r *http.Request, action string) {
+	switch {
+	case action == "duplicates" && r.Method == "GET":
+		query := "SELECT id, name, lat, lng FROM places WHERE status = 'pending' ORDER BY id DESC"
+		var args []interface{}
+		if id := r.URL.Query().Get("id"); id != "" {
+			query = "SELECT id, name, lat, lng FROM places WHERE id = $1"
+			args = append(args, id)
+		}
+		rows, err := db.Query(query, args...)
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		type group struct {
+			PlaceID    int                  `json:"place_id"`
+			Name       map[string]string    `json:"name"`
+			Candidates []DuplicateCandidate `json:"candidates"`
+		}
+		type place struct {
+			id       int
+			name     map[string]string
+			lat, lng float64
+		}
+		var places []place
+		for rows.Next() {
+			var p place
+			var nameJSON []byte
+			rows.Scan(&p.id, &nameJSON, &p.lat, &p.lng)
+			json.Unmarshal(nameJSON, &p.name)
+			places = append(places, p)
+		}
+		rows.Close()
+		groups := []group{}
+		for _, p := range places {
+			candidates, err := findDuplicatePlaces(p.lat, p.lng, p.name, p.id)
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			if len(candidates) > 0 { groups = append(groups, group{p.id, p.name, candidates}) }
+		}
+		json.NewEncoder(w).Encode(groups)
+	case action == "merge" && r.Method == "POST":
+		var req struct {
+			SourceID int `json:"source_id"`
+			TargetID int `json:"target_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SourceID <= 0 || req.TargetID <= 0 { http.Error(w, "source_id and target_id are required", http.StatusBadRequest); return }
+		req.TargetID = resolvePlaceID(req.TargetID)
+		adminID, _ := currentUser(r)
+		var released string
+		err := withTx(func(tx *sql.Tx) error {
+			var err error
+			released, err = mergePlaces(tx, req.SourceID, req.TargetID, adminID)
+			return err
+		})
+		if err == errMergeSelf { http.Error(w, err.Error(), http.StatusBadRequest); return }
+		if err == sql.ErrNoRows { http.Error(w, "Place not found", http.StatusNotFound); return }
+		if err != nil {
+			log.Printf("Merge %d into %d: %v", req.SourceID, req.TargetID, err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		releaseUpload(released)
+		json.NewEncoder(w).Encode(map[string]interface{}{"source_id": req.SourceID, "target_id": req.TargetID, "merged_at": time.Now()})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// placeRedirect answers GET /api/places?id= for a merged place with a
+// permanent redirect to the surviving one. It reports whether it did.
+func placeRedirect(w http.ResponseWriter, r *http.Request, id int) bool {
+	newID := resolvePlaceID(id)
+	if newID == id { return false }
+	q := r.URL.Query()
+	q.Set("id", strconv.Itoa(newID))
+	http.Redirect(w, r, r.URL.Path+"?"+q.Encode(), http.StatusMovedPermanently)
+	return true
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// --- Export ---
+//
+// GET /api/export writes places as GeoJSON, KML or GPX waypoints. Rows are
+// streamed straight from the database to the response, so exporting every
+// approved place doesn't buffer the whole set.
+
+const exportFlushEvery = 500
+
+// placeWriter streams places in one output format.
+type placeWriter interface {
+	begin(title string) error
+	place(p Place, lang string) error
+	end() error
+}
+
+type exportFormat struct {
+	contentType string
+	ext         string
+	writer      func(w io.Writer) placeWriter
+}
+
+var exportFormats = map[string]exportFormat{
+	"geojson": {"application/geo+json", "geojson", func(w io.Writer) placeWriter { return &geojsonWriter{w: w} }},
+	"kml":     {"application/vnd.google-earth.kml+xml", "kml", func(w io.Writer) placeWriter { return &kmlWriter{w: w, enc: xml.NewEncoder(w)} }},
+	"gpx":     {"application/gpx+xml", "gpx", func(w io.Writer) placeWriter { return &gpxWriter{w: w, enc: xml.NewEncoder(w)} }},
+}
+
+type geojsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+type geojsonFeature struct {
+	Type     string `json:"type"`
+	ID       int    `json:"id"`
+	Geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	} `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func (g *geojsonWriter) begin(title string) error {
+	name, _ := json.Marshal(title)
+	_, err := fmt.Fprintf(g.w, `{"type":"FeatureCollection","name":%s,"features":[`, name)
+	return err
+}
+
+func (g *geojsonWriter) place(p Place, lang string) error {
+	f := geojsonFeature{Type: "Feature", ID: p.ID}
+	f.Geometry.Type = "Point"
+	f.Geometry.Coordinates = [2]float64{p.Lng, p.Lat}
+	f.Properties = map[string]interface{}{
+		"name": localizedName(p.Name, lang), "description": localizedName(p.Description, lang),
+		"category": p.Category, "city": p.City, "image_url": p.ImageURL,
+	}
+	data, err := json.Marshal(f)
+	if err != nil { return err }
+	if g.count > 0 { g.w.Write([]byte(",")) }
+	g.count++
+	_, err = g.w.Write(data)
+	return err
+}
+
+func (g *geojsonWriter) end() error {
+	_, err := io.WriteString(g.w, "]}\n")
+	return err
+}
+
+// escapeXML escapes s for use as XML character data.
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+type kmlWriter struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+func (k *kmlWriter) begin(title string) error {
+	_, err := io.WriteString(k.w, xml.Header+`<kml xmlns="http://www.opengis.net/kml/2.2"><Document><name>`+escapeXML(title)+"</name>")
+	return err
+}
+
+func (k *kmlWriter) place(p Place, lang string) error {
+	pm := kmlPlacemark{Name: localizedName(p.Name, lang), Description: localizedName(p.Description, lang)}
+	pm.Point = &struct {
+		Coordinates string `xml:"coordinates"`
+	}{kmlCoord(p.Lat, p.Lng)}
+	for _, d := range []kmlData{{"category", p.Category}, {"city", p.City}} {
+		if d.Value != "" { pm.Data = append(pm.Data, d) }
+	}
+	return k.enc.EncodeElement(pm, xml.StartElement{Name: xml.Name{Local: "Placemark"}})
+}
+
+func (k *kmlWriter) end() error {
+	_, err := io.WriteString(k.w, "</Document></kml>\n")
+	return err
+}
+
+type gpxWriter struct {
+	w   io.Writer
+	enc *xml.Encoder
+}
+
+func (g *gpxWriter) begin(title string) error {
+	_, err := io.WriteString(g.w, xml.Header+`<gpx version="1.1" creator="Maplas" xmlns="http://www.topografix.com/GPX/1/1"><metadata><name>`+escapeXML(title)+"</name></metadata>")
+	return err
+}
+
+func (g *gpxWriter) place(p Place, lang string) error {
+	return g.enc.EncodeElement(gpxPoint{Lat: p.Lat, Lon: p.Lng, Name: localizedName(p.Name, lang), Desc: localizedName(p.Description, lang)}, xml.StartElement{Name: xml.Name{Local: "wpt"}})
+}
+
+func (g *gpxWriter) end() error {
+	_, err := io.WriteString(g.w, "</gpx>\n")
+	return err
+}
+
+// exportQuery builds the SELECT for scope places (approved, filtered by
+// city, category and bbox=minLng,minLat,maxLng,maxLat) or favorites.
+func exportQuery(r *http.Request, scope string, userID int) (string, []interface{}, string) {
+	q := r.URL.Query()
+	query := "SELECT p.id, p.name, p.description, p.lat, p.lng, p.category, p.city, COALESCE(p.image_url, '') FROM places p"
+	var args []interface{}
+	var where []string
+	if scope == "favorites" {
+		args = append(args, userID)
+		query += " JOIN favorites f ON f.place_id = p.id AND f.user_id = $1"
+	}
+	where = append(where, "p.status = 'approved'")
+	if city := q.Get("city"); city != "" {
+		args = append(args, city)
+		where = append(where, fmt.Sprintf("p.city = $%d", len(args)))
+	}
+	if category := q.Get("category"); category != "" {
+		if slug, ok := resolveCategory(category); ok { category = slug }
+		args = append(args, category)
+		where = append(where, fmt.Sprintf("p.category = $%d", len(args)))
+	}
+	if bbox := q.Get("bbox"); bbox != "" {
+		parts := strings.Split(bbox, ",")
+		if len(parts) != 4 { return "", nil, "bbox must be minLng,minLat,maxLng,maxLat" }
+		var box [4]float64
+		for i, s := range parts {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil { return "", nil, "Invalid bbox" }
+			box[i] = v
+		}
+		args = append(args, box[0], box[1], box[2], box[3])
+		n := len(args)
+		where = append(where, fmt.Sprintf("p.lng BETWEEN $%d AND $%d AND p.lat BETWEEN $%d AND $%d", n-3, n-1, n-2, n))
+	}
+	return query + " WHERE " + strings.Join(where, " AND ") + " ORDER BY p.id", args, ""
+}
+
+// exportHandler serves GET /api/export with format=geojson|kml|gpx,
+// scope=places (default, filterable) | favorites (own) | list (&id=), and
+// lang for localized names and descriptions.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "GET" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	q := r.URL.Query()
+	format, ok := exportFormats[q.Get("format")]
+	if !ok { http.Error(w, "format must be geojson, kml or gpx", http.StatusBadRequest); return }
+	lang := q.Get("lang")
+	if lang == "" { lang = "tr" }
+	userID, claims := currentUser(r)
+	scope := q.Get("scope")
+	if scope == "" { scope = "places" }
+
+	var list *PlaceList
+	var rows *sql.Rows
+	title := "Maplas places"
+	switch scope {
+	case "places", "favorites":
+		if scope == "favorites" {
+			if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+			title = "Maplas favorites"
+		}
+		query, args, msg := exportQuery(r, scope, userID)
+		if msg != "" { http.Error(w, msg, http.StatusBadRequest); return }
+		var err error
+		if rows, err = db.Query(query, args...); err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		defer rows.Close()
+	case "list":
+		id, err := strconv.Atoi(q.Get("id"))
+		if err != nil { http.Error(w, "Invalid list ID", http.StatusBadRequest); return }
+		list, err = loadList(id, userID, claims != nil && claims.Role == "admin")
+		if err == sql.ErrNoRows { http.Error(w, "List not found", http.StatusNotFound); return }
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		title = list.Title
+	default:
+		http.Error(w, "scope must be places, favorites or list", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"maplas-%s.%s\"", scope, format.ext))
+	pw := format.writer(w)
+	flusher, _ := w.(http.Flusher)
+	if err := pw.begin(title); err != nil { return }
+	if list != nil {
+		for _, e := range list.Entries {
+			if err := pw.place(e.Place, lang); err != nil { return }
+		}
+	} else {
+		for n := 1; rows.Next(); n++ {
+			var p Place
+			var nameJSON, descJSON []byte
+			if err := rows.Scan(&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.ImageURL); err != nil {
+				log.Printf("Export: %v", err)
+				return
+			}
+			json.Unmarshal(nameJSON, &p.Name)
+			json.Unmarshal(descJSON, &p.Description)
+			if err := pw.place(p, lang); err != nil { return }
+			if flusher != nil && n%exportFlushEvery == 0 { flusher.Flush() }
+		}
+		// The status line is gone by now; a truncated document is all we can signal
+		if err := rows.Err(); err != nil { log.Printf("Export: %v", err); return }
+	}
+	pw.end()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+func TestKMLExportPlacemarks(t *testing.T) {
+	places := []Place{
+		{ID: 1, Name: map[string]string{"tr": "Ayasofya", "en": "Hagia Sophia"}, Lat: 41.0086, Lng: 28.9802, Category: "historical", City: "İstanbul"},
+		{ID: 2, Name: map[string]string{"tr": "Sümela <Manastırı>"}, Description: map[string]string{"tr": "Kayalık & vadi"}, Lat: 40.6903, Lng: 39.6583},
+	}
+	var buf bytes.Buffer
+	k := exportFormats["kml"].writer(&buf)
+	if err := k.begin("Yerler"); err != nil { t.Fatal(err) }
+	for _, p := range places {
+		if err := k.place(p, "tr"); err != nil { t.Fatal(err) }
+	}
+	if err := k.end(); err != nil { t.Fatal(err) }
+
+	var doc struct {
+		XMLName    xml.Name `xml:"http://www.opengis.net/kml/2.2 kml"`
+		Name       string   `xml:"Document>name"`
+		Placemarks []struct {
+			Name        string    `xml:"name"`
+			Description string    `xml:"description"`
+			Coordinates string    `xml:"Point>coordinates"`
+			Data        []kmlData `xml:"ExtendedData>Data"`
+		} `xml:"Document>Placemark"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil { t.Fatalf("invalid KML: %v\n%s", err, buf.String()) }
+	if doc.Name != "Yerler" { t.Errorf("document name = %q", doc.Name) }
+	if len(doc.Placemarks) != len(places) { t.Fatalf("got %d Placemarks, want %d:\n%s", len(doc.Placemarks), len(places), buf.String()) }
+	first, second := doc.Placemarks[0], doc.Placemarks[1]
+	if first.Name != "Ayasofya" || first.Coordinates != "28.9802,41.0086" { t.Errorf("first placemark = %+v", first) }
+	if len(first.Data) != 2 || first.Data[0] != (kmlData{"category", "historical"}) || first.Data[1] != (kmlData{"city", "İstanbul"}) { t.Errorf("first placemark data = %+v", first.Data) }
+	if second.Name != "Sümela <Manastırı>" || second.Description != "Kayalık & vadi" || len(second.Data) != 0 { t.Errorf("second placemark = %+v", second) }
+}
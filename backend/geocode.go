@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Forward geocoding ---
+//
+// GET /api/geocode?q= resolves an address or place name for the add-place
+// map, so browsers never talk to Nominatim directly. GEOCODER selects the
+// provider: "nominatim" (default; NOMINATIM_URL, GEOCODER_USER_AGENT,
+// NOMINATIM_EMAIL) or "offline", which only searches our places, the
+// bundled provinces and an optional GEOCODER_GAZETTEER file. Each client
+// (user, or IP when signed out) gets GEOCODER_CLIENT_LIMIT requests a
+// minute so one caller can't use up the shared Nominatim allowance.
+
+// Geocoder turns free text into candidate locations, best first.
+type Geocoder interface {
+	Search(ctx context.Context, query, lang string, limit int) ([]GeocodeResult, error)
+}
+
+type GeocodeResult struct {
+	Name        string  `json:"name"`
+	DisplayName string  `json:"display_name"`
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	City        string  `json:"city,omitempty"`
+	District    string  `json:"district,omitempty"`
+	PlaceID     int     `json:"place_id,omitempty"` // Set for matches on our own places
+	Source      string  `json:"source"`             // "nominatim", "place", "province" or "gazetteer"
+}
+
+var errGeocoderBusy = errors.New("geocoder busy")
+
+const (
+	geocodeMaxLimit     = 10
+	geocodeMaxQueryLen  = 200
+	geocodeCacheMaxKeys = 5000
+)
+
+var geocodeCacheTTL = envDuration("GEOCODER_CACHE_TTL", 24*time.Hour)
+var geocodeClientLimit = envInt("GEOCODER_CLIENT_LIMIT", 30) // Per minute; 0 disables
+
+// geocoder is the provider used by geocodeHandler, set up in main.
+var geocoder Geocoder
+
+// newGeocoder builds the provider selected by GEOCODER, wrapped in the
+// result cache.
+func newGeocoder() (Geocoder, error) {
+	var inner Geocoder
+	switch getEnv("GEOCODER", "nominatim") {
+	case "nominatim":
+		inner = &nominatimGeocoder{
+			baseURL:   strings.TrimSuffix(getEnv("NOMINATIM_URL", "https://nominatim.openstreetmap.org"), "/"),
+			userAgent: getEnv("GEOCODER_USER_AGENT", "Maplas/1.0 (https://github.com/maplas)"),
+			email:     getEnv("NOMINATIM_EMAIL", ""),
+			countries: getEnv("GEOCODER_COUNTRIES", "tr"),
+			interval:  envDuration("NOMINATIM_INTERVAL", time.Second), // Public instance policy: at most 1 request/s
+			maxWait:   envDuration("NOMINATIM_MAX_WAIT", 3*time.Second),
+			client:    &http.Client{Timeout: 10 * time.Second},
+		}
+	case "offline":
+		g, err := newOfflineGeocoder(getEnv("GEOCODER_GAZETTEER", ""))
+		if err != nil { return nil, err }
+		inner = g
+	default:
+		return nil, fmt.Errorf("unknown GEOCODER %q", getEnv("GEOCODER", ""))
+	}
+	return &cachedGeocoder{inner: inner, entries: map[string]geocodeCacheEntry{}}, nil
+}
+
+// --- Cache ---
+
+type geocodeCacheEntry struct {
+	results []GeocodeResult
+	expires time.Time
+}
+
+type cachedGeocoder struct {
+	inner   Geocoder
+	mu      sync.Mutex
+	entries map[string]geocodeCacheEntry
+}
+
+func (c *cachedGeocoder) Search(ctx context.Context, query, lang string, limit int) ([]GeocodeResult, error) {
+	key := lang + "|" + strconv.Itoa(limit) + "|" + foldName(query)
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) { return e.results, nil }
+	results, err := c.inner.Search(ctx, query, lang, limit)
+	if err != nil { return nil, err }
+	c.mu.Lock()
+	if len(c.entries) >= geocodeCacheMaxKeys { c.entries = map[string]geocodeCacheEntry{} }
+	c.entries[key] = geocodeCacheEntry{results: results, expires: time.Now().Add(geocodeCacheTTL)}
+	c.mu.Unlock()
+	return results, nil
+}
+
+// --- Per-client limit ---
+
+const (
+	geocodeClientWindow  = time.Minute
+	geocodeClientMaxKeys = 10000
+)
+
+type geocodeWindow struct {
+	start time.Time
+	count int
+}
+
+type geocodeLimiter struct {
+	limit   int
+	mu      sync.Mutex
+	clients map[string]*geocodeWindow
+}
+
+var geocodeClients = &geocodeLimiter{limit: geocodeClientLimit, clients: map[string]*geocodeWindow{}}
+
+// allow counts a request from key in its current window and returns how
+// long to wait when the client is over the limit.
+func (l *geocodeLimiter) allow(key string, now time.Time) (time.Duration, bool) {
+	if l.limit <= 0 { return 0, true }
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w := l.clients[key]
+	if w == nil || now.Sub(w.start) >= geocodeClientWindow {
+		if len(l.clients) >= geocodeClientMaxKeys { l.clients = map[string]*geocodeWindow{} }
+		w = &geocodeWindow{start: now}
+		l.clients[key] = w
+	}
+	if w.count >= l.limit { return w.start.Add(geocodeClientWindow).Sub(now), false }
+	w.count++
+	return 0, true
+}
+
+// geocodeClientKey is the signed-in user, else the remote address.
+func geocodeClientKey(r *http.Request) string {
+	if userID, _ := currentUser(r); userID != 0 { return "user:" + strconv.Itoa(userID) }
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil { host = r.RemoteAddr }
+	return "ip:" + host
+}
+
+// --- Nominatim ---
+
+// nominatimGeocoder calls a Nominatim-compatible /search endpoint,
+// spacing requests by interval across all callers. A request that would
+// wait longer than maxWait fails with errGeocoderBusy instead of queueing.
+type nominatimGeocoder struct {
+	baseURL, userAgent, email, countries string
+	interval, maxWait                    time.Duration
+	client                               *http.Client
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (n *nominatimGeocoder) wait(ctx context.Context) error {
+	n.mu.Lock()
+	now := time.Now()
+	if n.next.Before(now) { n.next = now }
+	delay := n.next.Sub(now)
+	if delay > n.maxWait { n.mu.Unlock(); return errGeocoderBusy }
+	n.next = n.next.Add(n.interval)
+	n.mu.Unlock()
+	if delay == 0 { return nil }
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *nominatimGeocoder) Search(ctx context.Context, query, lang string, limit int) ([]GeocodeResult, error) {
+	if err := n.wait(ctx); err != nil { return nil, err }
+	params := url.Values{"q": {query}, "format": {"jsonv2"}, "addressdetails": {"1"}, "limit": {strconv.Itoa(limit)}, "accept-language": {lang}}
+	if n.countries != "" { params.Set("countrycodes", n.countries) }
+	if n.email != "" { params.Set("email", n.email) }
+	req, err := http.NewRequestWithContext(ctx, "GET", n.baseURL+"/search?"+params.Encode(), nil)
+	if err != nil { return nil, err }
+	req.Header.Set("User-Agent", n.userAgent)
+	resp, err := n.client.Do(req)
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests { return nil, errGeocoderBusy }
+	if resp.StatusCode != http.StatusOK { return nil, fmt.Errorf("nominatim: %s", resp.Status) }
+	var hits []struct {
+		Name        string            `json:"name"`
+		DisplayName string            `json:"display_name"`
+		Lat         string            `json:"lat"`
+		Lon         string            `json:"lon"`
+		Address     map[string]string `json:"address"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&hits); err != nil { return nil, fmt.Errorf("nominatim: %w", err) }
+	results := []GeocodeResult{}
+	for _, h := range hits {
+		lat, err1 := strconv.ParseFloat(h.Lat, 64)
+		lng, err2 := strconv.ParseFloat(h.Lon, 64)
+		if err1 != nil || err2 != nil { continue }
+		res := GeocodeResult{Name: h.Name, DisplayName: h.DisplayName, Lat: lat, Lng: lng, Source: "nominatim"}
+		if res.Name == "" { res.Name, _, _ = strings.Cut(h.DisplayName, ",") }
+		// Our own city/district naming, not whatever admin level OSM tagged
+		res.City, res.District = resolveCity(lat, lng, h.Address["province"])
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// --- Offline ---
+
+// offlineGeocoder matches the query against approved places, province
+// names and gazetteer entries. It needs no network, which makes it the
+// provider for tests and air-gapped installs.
+type offlineGeocoder struct {
+	gazetteer []GeocodeResult
+}
+
+// newOfflineGeocoder loads an optional gazetteer in any import format
+// (.geojson, .kml or .csv with name,lat,lng[,city]).
+func newOfflineGeocoder(path string) (*offlineGeocoder, error) {
+	g := &offlineGeocoder{}
+	if path == "" { return g, nil }
+	data, err := os.ReadFile(path)
+	if err != nil { return nil, err }
+	var rows []ImportRow
+	switch {
+	case strings.HasSuffix(path, ".csv"):
+		rows, err = parseCSV(data)
+	case strings.HasSuffix(path, ".kml"):
+		rows, err = parseKML(data)
+	default:
+		rows, err = parseGeoJSON(data)
+	}
+	if err != nil { return nil, fmt.Errorf("gazetteer %s: %w", path, err) }
+	for _, row := range rows {
+		if row.Name == "" || !importCoordsOK(row) { continue }
+		city, district := resolveCity(row.Lat, row.Lng, row.City)
+		g.gazetteer = append(g.gazetteer, GeocodeResult{Name: row.Name, DisplayName: joinNonEmpty(row.Name, district, city), Lat: row.Lat, Lng: row.Lng, City: city, District: district, Source: "gazetteer"})
+	}
+	log.Printf("Geocoder: %d gazetteer entries from %s", len(g.gazetteer), path)
+	return g, nil
+}
+
+func joinNonEmpty(parts ...string) string {
+	var out []string
+	for _, p := range parts {
+		if p != "" && (len(out) == 0 || out[len(out)-1] != p) { out = append(out, p) }
+	}
+	return strings.Join(out, ", ")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// geocodeScore ranks a name against the folded query: exact, prefix,
+// word prefix, substring; 0 means no match.
+func geocodeScore(name, query string) int {
+	n := foldName(name)
+	switch {
+	case n == query:
+		return 4
+	case strings.HasPrefix(n, query):
+		return 3
+	case strings.Contains(" "+n, " "+query):
+		return 2
+	case strings.Contains(n, query):
+		return 1
+	}
+	return 0
+}
+
+func (o *offlineGeocoder) Search(ctx context.Context, query, lang string, limit int) ([]GeocodeResult, error) {
+	q := foldName(query)
+	type scored struct {
+		GeocodeResult
+		score int
+	}
+	var hits []scored
+
+	rows, err := db.QueryContext(ctx, `SELECT id, name, lat, lng, COALESCE(city, ''), COALESCE(district, '') FROM places
+		WHERE status = 'approved' AND EXISTS (SELECT 1 FROM jsonb_each_text(name) n WHERE n.value ILIKE '%' || $1 || '%')
+		LIMIT 200`, likeEscaper.Replace(strings.TrimSpace(query)))
+	if err != nil { return nil, err }
+	for rows.Next() {
+		var res GeocodeResult
+		var nameJSON []byte
+		rows.Scan(&res.PlaceID, &nameJSON, &res.Lat, &res.Lng, &res.City, &res.District)
+		var name map[string]string
+		json.Unmarshal(nameJSON, &name)
+		res.Name, res.Source = localizedName(name, lang), "place"
+		res.DisplayName = joinNonEmpty(res.Name, res.District, res.City)
+		best := 0
+		for _, n := range name {
+			if s := geocodeScore(n, q); s > best { best = s }
+		}
+		// Our own places win ties against administrative names
+		if best > 0 { hits = append(hits, scored{res, best*2 + 1}) }
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil { return nil, err }
+
+	if g := geo.Load(); g != nil {
+		for _, p := range g.provinces {
+			best := geocodeScore(p.Name, q)
+			for _, a := range p.Aliases {
+				if s := geocodeScore(a, q); s > best { best = s }
+			}
+			if best > 0 { hits = append(hits, scored{GeocodeResult{Name: p.Name, DisplayName: p.Name, Lat: p.Lat, Lng: p.Lng, City: p.Name, Source: "province"}, best * 2}) }
+		}
+	}
+	for _, e := range o.gazetteer {
+		if s := geocodeScore(e.Name, q); s > 0 { hits = append(hits, scored{e, s * 2}) }
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	results := []GeocodeResult{}
+	for _, h := range hits {
+		if len(results) == limit { break }
+		results = append(results, h.GeocodeResult)
+	}
+	return results, nil
+}
+
+// geocodeHandler serves GET /api/geocode?q=&lang=&limit= (default 5).
+func geocodeHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "GET" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" || len(query) > geocodeMaxQueryLen { http.Error(w, "q is required (max 200 characters)", http.StatusBadRequest); return }
+	lang := q.Get("lang")
+	if lang == "" { lang = "tr" }
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 { limit = 5 }
+	if limit > geocodeMaxLimit { limit = geocodeMaxLimit }
+	if wait, ok := geocodeClients.allow(geocodeClientKey(r), time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+		http.Error(w, "Too many geocoding requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	results, err := geocoder.Search(r.Context(), query, lang, limit)
+	if err == errGeocoderBusy { w.Header().Set("Retry-After", "1"); http.Error(w, "Geocoder busy, try again", http.StatusServiceUnavailable); return }
+	if err != nil {
+		log.Printf("Geocode: %v", err)
+		http.Error(w, "Geocoding failed", http.StatusBadGateway)
+		return
+	}
+	json.NewEncoder(w).Encode(results)
+}
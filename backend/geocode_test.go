@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGeocodeScore(t *testing.T) {
+	tests := []struct {
+		name, query string
+		want        int
+	}{
+		{"İstanbul", "istanbul", 4},
+		{"ISTANBUL", "istanbul", 4},
+		{"İstanbul Modern", "istanbul", 3},
+		{"Ayasofya Camii", "camii", 2},
+		{"Göreme Açık Hava Müzesi", "acik hava", 2},
+		{"Kapadokya", "dok", 1},
+		{"Ankara", "izmir", 0},
+		{"Efes", "efes antik kenti", 0},
+	}
+	for _, tt := range tests {
+		if got := geocodeScore(tt.name, tt.query); got != tt.want {
+			t.Errorf("geocodeScore(%q, %q) = %d, want %d", tt.name, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestGeocodeLimiter(t *testing.T) {
+	l := &geocodeLimiter{limit: 2, clients: map[string]*geocodeWindow{}}
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if _, ok := l.allow("ip:10.0.0.1", now); !ok { t.Fatalf("request %d refused", i+1) }
+	}
+	wait, ok := l.allow("ip:10.0.0.1", now.Add(20*time.Second))
+	if ok || wait != 40*time.Second { t.Errorf("third request: ok=%v wait=%v, want refused with 40s", ok, wait) }
+	if _, ok := l.allow("ip:10.0.0.2", now); !ok { t.Error("other client refused") }
+	if _, ok := l.allow("ip:10.0.0.1", now.Add(geocodeClientWindow)); !ok { t.Error("refused after the window") }
+
+	off := &geocodeLimiter{clients: map[string]*geocodeWindow{}}
+	if _, ok := off.allow("ip:10.0.0.1", now); !ok { t.Error("limit 0 should disable the limiter") }
+}
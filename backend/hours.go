@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Place timezones must resolve even on images without zoneinfo
+)
+
+// --- Opening hours ---
+//
+// A subset of the OSM opening_hours syntax: rules separated by ";", each
+// an optional month selector (Apr-Oct), optional weekday selector (Mo-Fr,Su)
+// and either comma-separated time spans (09:00-12:00,13:00-18:00) or
+// "off"/"closed". "24/7" is always open. Later rules override earlier ones
+// for the days they match, as in OSM; spans past midnight (22:00-02:00)
+// carry into the next day. Public holiday selectors (PH) are accepted but
+// never match, since we have no holiday calendar.
+
+var weekdayNames = []string{"Su", "Mo", "Tu", "We", "Th", "Fr", "Sa"}
+var monthNames = []string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+var hoursSeparatorSpace = regexp.MustCompile(`\s*([,-])\s*`)
+
+type hoursRule struct {
+	months [12]bool // Index time.Month-1
+	days   [7]bool  // Index time.Weekday
+	spans  [][2]int // Minutes from midnight; end > 1440 runs into the next day
+	off    bool
+}
+
+// OpeningHours is a parsed opening_hours value.
+type OpeningHours []hoursRule
+
+func indexOf(names []string, s string) int {
+	for i, n := range names {
+		if n == s { return i }
+	}
+	return -1
+}
+
+// parseSelector fills set from "A-B,C" using names; ranges may wrap
+// (Fr-Mo, Nov-Feb). ok is false when s isn't made of these names.
+func parseSelector(s string, names []string, set []bool) bool {
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		a, b := indexOf(names, from), indexOf(names, from)
+		if isRange { b = indexOf(names, to) }
+		if a < 0 || b < 0 { return false }
+		for i := a; ; i = (i + 1) % len(names) {
+			set[i] = true
+			if i == b { break }
+		}
+	}
+	return true
+}
+
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hh < 0 || hh > 24 || mm < 0 || mm > 59 || (hh == 24 && mm > 0) { return 0, fmt.Errorf("invalid time %q", s) }
+	return hh*60 + mm, nil
+}
+
+func parseOpeningHours(s string) (OpeningHours, error) {
+	var rules OpeningHours
+	for _, raw := range strings.Split(s, ";") {
+		raw = hoursSeparatorSpace.ReplaceAllString(strings.TrimSpace(raw), "$1")
+		if raw == "" { continue }
+		var r hoursRule
+		if raw == "24/7" {
+			for i := range r.months { r.months[i] = true }
+			for i := range r.days { r.days[i] = true }
+			r.spans = [][2]int{{0, 1440}}
+			rules = append(rules, r)
+			continue
+		}
+		fields := strings.Fields(raw)
+		if len(fields) > 0 && parseSelector(fields[0], monthNames, r.months[:]) {
+			fields = fields[1:]
+		} else {
+			for i := range r.months { r.months[i] = true }
+		}
+		holidaysOnly := false
+		if len(fields) > 0 && fields[0] != "off" && fields[0] != "closed" && !strings.ContainsAny(fields[0][:1], "0123456789") {
+			sel := fields[0]
+			fields = fields[1:]
+			var days []string
+			for _, d := range strings.Split(sel, ",") {
+				if d != "PH" && d != "SH" { days = append(days, d) }
+			}
+			holidaysOnly = len(days) == 0
+			if !holidaysOnly && !parseSelector(strings.Join(days, ","), weekdayNames, r.days[:]) { return nil, fmt.Errorf("invalid selector %q", sel) }
+		} else {
+			for i := range r.days { r.days[i] = true }
+		}
+		if len(fields) != 1 { return nil, fmt.Errorf("invalid rule %q", raw) }
+		if fields[0] == "off" || fields[0] == "closed" {
+			r.off = true
+		} else {
+			for _, span := range strings.Split(fields[0], ",") {
+				from, to, ok := strings.Cut(span, "-")
+				if !ok { return nil, fmt.Errorf("invalid time span %q", span) }
+				a, err := parseClock(from)
+				if err != nil { return nil, err }
+				b, err := parseClock(to)
+				if err != nil { return nil, err }
+				if b <= a { b += 1440 }
+				r.spans = append(r.spans, [2]int{a, b})
+			}
+		}
+		if !holidaysOnly { rules = append(rules, r) }
+	}
+	if len(rules) == 0 { return nil, fmt.Errorf("no rules") }
+	return rules, nil
+}
+
+// spansOn returns the spans of the last rule matching day, or nil when
+// that rule is "off" or none matches.
+func (h OpeningHours) spansOn(day time.Time) [][2]int {
+	var spans [][2]int
+	for _, r := range h {
+		if !r.months[day.Month()-1] || !r.days[day.Weekday()] { continue }
+		spans = r.spans
+		if r.off { spans = nil }
+	}
+	return spans
+}
+
+// openAt reports whether the place is open at t, which must already be in
+// the place's timezone.
+func (h OpeningHours) openAt(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	for _, s := range h.spansOn(t) {
+		if m >= s[0] && m < s[1] { return true }
+	}
+	for _, s := range h.spansOn(t.AddDate(0, 0, -1)) {
+		if s[1] > 1440 && m < s[1]-1440 { return true }
+	}
+	return false
+}
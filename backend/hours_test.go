@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpeningHoursOpenAt(t *testing.T) {
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		hours string
+		at    time.Time
+		want  bool
+	}{
+		// Past midnight: Saturday's span runs into Sunday morning
+		{"22:00-02:00", at(time.July, 18, 23, 0), true},
+		{"22:00-02:00", at(time.July, 19, 1, 30), true},
+		{"22:00-02:00", at(time.July, 19, 2, 0), false},
+		{"22:00-02:00", at(time.July, 18, 21, 59), false},
+		{"Mo-Fr 09:00-17:00; Sa off; Fr 22:00-03:00", at(time.July, 18, 2, 30), true},
+		{"Mo-Fr 09:00-17:00; Sa off; Fr 22:00-03:00", at(time.July, 17, 12, 0), false},
+
+		// Split days, PH off never matches
+		{"Mo-Fr 09:00-12:00,13:00-18:00; PH off", at(time.July, 15, 10, 0), true},
+		{"Mo-Fr 09:00-12:00,13:00-18:00; PH off", at(time.July, 15, 12, 30), false},
+		{"Mo-Fr 09:00-12:00,13:00-18:00; PH off", at(time.July, 15, 17, 59), true},
+		{"Mo-Fr 09:00-12:00,13:00-18:00; PH off", at(time.July, 18, 10, 0), false},
+		{"PH 10:00-12:00; Mo-Su 09:00-10:00", at(time.July, 15, 11, 0), false},
+
+		// Month plus weekday rules
+		{"Apr-Oct Mo-Su 08:00-19:00; Nov-Mar Mo-Su 08:30-17:00", at(time.July, 15, 18, 30), true},
+		{"Apr-Oct Mo-Su 08:00-19:00; Nov-Mar Mo-Su 08:30-17:00", at(time.January, 14, 18, 30), false},
+		{"Apr-Oct Mo-Su 08:00-19:00; Nov-Mar Mo-Su 08:30-17:00", at(time.January, 14, 8, 15), false},
+		{"Apr-Oct Mo-Su 08:00-19:00; Nov-Mar Mo-Su 08:30-17:00", at(time.April, 15, 8, 15), true},
+		{"Apr-Oct Tu-Su 09:00-17:00", at(time.July, 15, 10, 0), true},
+		{"Apr-Oct Tu-Su 09:00-17:00", at(time.January, 14, 10, 0), false},
+
+		// Wrapping weekday range and later rules overriding earlier ones
+		{"Fr-Mo 10:00-16:00", at(time.July, 19, 11, 0), true},
+		{"Fr-Mo 10:00-16:00", at(time.July, 15, 11, 0), false},
+		{"Mo-Su 09:00-17:00; We off", at(time.July, 15, 11, 0), false},
+		{"24/7", at(time.July, 15, 3, 0), true},
+	}
+	for _, tt := range tests {
+		h, err := parseOpeningHours(tt.hours)
+		if err != nil { t.Errorf("parseOpeningHours(%q): %v", tt.hours, err); continue }
+		if got := h.openAt(tt.at); got != tt.want {
+			t.Errorf("%q at %s: open = %v, want %v", tt.hours, tt.at.Format("Mon Jan 2 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestParseOpeningHoursInvalid(t *testing.T) {
+	for _, s := range []string{"", ";", "Mo", "09:00", "Mo-Fx 09:00-10:00", "25:00-26:00", "09:60-10:00", "Mo 09:00-10:00 extra"} {
+		if _, err := parseOpeningHours(s); err == nil { t.Errorf("parseOpeningHours(%q) accepted invalid input", s) }
+	}
+}
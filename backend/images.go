@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// --- Responsive image variants ---
+
+// ImageVariants holds resized copies of an uploaded image, keyed by size.
+type ImageVariants struct {
+	Thumb  string `json:"thumb"`
+	Medium string `json:"medium"`
+	Large  string `json:"large"`
+}
+
+// variantWidths are the only widths served; ?w= snaps to the nearest one
+// at or above the request so clients can't make us render arbitrary sizes.
+var variantWidths = []int{320, 800, 1600}
+
+// variantLocks serialises lazy generation of the same variant.
+var variantLocks sync.Map
+
+var variantKeyPattern = regexp.MustCompile(`_[ws]\d+\.[a-z]+$`)
+
+// imageVariants builds the variant URLs for an image. It returns nil for
+// empty or external URLs.
+func imageVariants(url string) *ImageVariants {
+	id := blobKeyFromURL(url)
+	if id == "" { return nil }
+	return &ImageVariants{
+		Thumb:  fmt.Sprintf("/uploads/%s?w=%d", id, variantWidths[0]),
+		Medium: fmt.Sprintf("/uploads/%s?w=%d", id, variantWidths[1]),
+		Large:  fmt.Sprintf("/uploads/%s?w=%d", id, variantWidths[2]),
+	}
+}
+
+// snapWidth picks the smallest variant width that is >= w.
+func snapWidth(w int) int {
+	for _, vw := range variantWidths {
+		if w <= vw { return vw }
+	}
+	return variantWidths[len(variantWidths)-1]
+}
+
+// variantName is the on-disk name of a resized copy, e.g. "abc_w320.jpg".
+// Legacy WebP originals get JPEG variants since we can't encode WebP.
+func variantName(id string, width int) string {
+	ext := filepath.Ext(id)
+	out := strings.ToLower(ext)
+	if out == ".webp" || out == ".jpeg" { out = ".jpg" }
+	return fmt.Sprintf("%s_w%d%s", strings.TrimSuffix(id, ext), width, out)
+}
+
+// variantKeys lists every variant key that may exist for an original,
+// including the square sizes of avatars.
+func variantKeys(id string) []string {
+	var keys []string
+	for _, width := range variantWidths { keys = append(keys, variantName(id, width)) }
+	if strings.HasPrefix(id, avatarKeyPrefix) {
+		for _, size := range avatarSizes[1:] { keys = append(keys, avatarVariantName(id, size)) }
+	}
+	return keys
+}
+
+// isVariantKey reports whether key names a resized copy rather than an
+// original upload.
+func isVariantKey(key string) bool {
+	return variantKeyPattern.MatchString(key)
+}
+
+// resizeImage scales img down to width, keeping the aspect ratio. Images
+// already narrower than width are returned unchanged.
+func resizeImage(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width { return img }
+	height := b.Dy() * width / b.Dx()
+	if height < 1 { height = 1 }
+	return scaleImage(img, width, height)
+}
+
+// scaleImage resamples img to exactly width x height.
+func scaleImage(img image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return dst
+}
+
+// generateVariants stores every variant for an already sanitized image.
+func generateVariants(ctx context.Context, id string, img image.Image) error {
+	for _, width := range variantWidths {
+		name := variantName(id, width)
+		data, err := encodeImage(resizeImage(img, width), filepath.Ext(name))
+		if err != nil { return err }
+		if err := blobs.Put(ctx, name, data, contentTypeForKey(name)); err != nil { return err }
+	}
+	return nil
+}
+
+// ensureVariant returns the key of a variant, generating it from the
+// original on first request. Uploads from before variants existed are
+// handled this way.
+func ensureVariant(ctx context.Context, id string, width int) (string, error) {
+	key := variantName(id, width)
+	if _, err := blobs.Stat(ctx, key); err == nil { return key, nil }
+
+	lock, _ := variantLocks.LoadOrStore(key, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	defer mu.Unlock()
+	defer variantLocks.Delete(key)
+	if _, err := blobs.Stat(ctx, key); err == nil { return key, nil }
+
+	rc, _, err := blobs.Get(ctx, id)
+	if err != nil { return "", err }
+	raw, err := io.ReadAll(io.LimitReader(rc, maxUploadBytes+1))
+	rc.Close()
+	if err != nil { return "", err }
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil { return "", err }
+	if cfg.Width*cfg.Height > maxImagePixels { return "", errImageTooLarge }
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil { return "", err }
+	data, err := encodeImage(resizeImage(img, width), filepath.Ext(key))
+	if err != nil { return "", err }
+	if err := blobs.Put(ctx, key, data, contentTypeForKey(key)); err != nil { return "", err }
+	return key, nil
+}
+
+// variantKey resolves the ?w= parameter of an upload request to a key.
+func variantKey(id string, r *http.Request) (string, error) {
+	wStr := r.URL.Query().Get("w")
+	if wStr == "" { return id, nil }
+	w, err := strconv.Atoi(wStr)
+	if err != nil || w <= 0 { return "", fmt.Errorf("invalid width") }
+	key, err := ensureVariant(r.Context(), id, snapWidth(w))
+	if err != nil { log.Printf("Variant %s@%d: %v", id, w, err) }
+	return key, err
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Bulk import ---
+//
+// POST /api/import takes a GeoJSON, KML or CSV file, validates every row
+// and flags likely duplicates of existing places (as POST /api/places
+// does) or of earlier rows. With dry_run=1 only the preview is returned;
+// otherwise all rows are inserted as pending places in one transaction, or
+// none are.
+
+const (
+	maxImportBytes = 10 << 20
+	maxImportRows  = 2000
+)
+
+var importDuplicateRadiusM = envFloat("IMPORT_DUPLICATE_RADIUS_M", 150)
+
+type ImportDuplicate struct {
+	PlaceID   int     `json:"place_id,omitempty"` // Existing place
+	Row       int     `json:"row,omitempty"`      // Earlier row in the same file
+	Name      string  `json:"name"`
+	DistanceM float64 `json:"distance_m"`
+}
+
+type ImportRow struct {
+	Row         int               `json:"row"` // 1-based, in file order
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Lat         float64           `json:"lat"`
+	Lng         float64           `json:"lng"`
+	Category    string            `json:"category"`
+	City        string            `json:"city"`
+	District    string            `json:"district,omitempty"`
+	Errors      []string          `json:"errors,omitempty"`
+	Duplicates  []ImportDuplicate `json:"duplicates,omitempty"`
+	PlaceID     int               `json:"place_id,omitempty"` // Set once created
+	Skipped     bool              `json:"skipped,omitempty"`
+}
+
+type ImportResult struct {
+	DryRun     bool        `json:"dry_run"`
+	Total      int         `json:"total"`
+	Invalid    int         `json:"invalid"`
+	Duplicates int         `json:"duplicates"`
+	Created    int         `json:"created"`
+	Rows       []ImportRow `json:"rows"`
+}
+
+// parseGeoJSON reads Point features from a FeatureCollection or a single
+// Feature.
+func parseGeoJSON(data []byte) ([]ImportRow, error) {
+	type feature struct {
+		Geometry *struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	var doc struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+		feature
+	}
+	if err := json.Unmarshal(data, &doc); err != nil { return nil, fmt.Errorf("invalid GeoJSON: %w", err) }
+	features := doc.Features
+	if doc.Type == "Feature" { features = []feature{doc.feature} }
+	prop := func(props map[string]interface{}, keys ...string) string {
+		for _, k := range keys {
+			switch v := props[k].(type) {
+			case string:
+				return strings.TrimSpace(v)
+			case map[string]interface{}:
+				// Localized {"tr": ..., "en": ...} as produced by our own export
+				names := map[string]string{}
+				for lang, s := range v {
+					if str, ok := s.(string); ok { names[lang] = str }
+				}
+				return strings.TrimSpace(localizedName(names, "tr"))
+			}
+		}
+		return ""
+	}
+	var rows []ImportRow
+	for i, f := range features {
+		row := ImportRow{Row: i + 1}
+		row.Name = prop(f.Properties, "name", "title", "Name")
+		row.Description = prop(f.Properties, "description", "desc", "Description")
+		row.Category = prop(f.Properties, "category", "Category")
+		row.City = prop(f.Properties, "city", "City")
+		if f.Geometry == nil || f.Geometry.Type != "Point" {
+			row.Errors = append(row.Errors, "geometry must be a Point")
+		} else {
+			var coords []float64
+			if json.Unmarshal(f.Geometry.Coordinates, &coords) == nil && len(coords) >= 2 { row.Lng, row.Lat = coords[0], coords[1] }
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseKML reads Point placemarks anywhere in the document. Category and
+// city come from ExtendedData <Data name="category|city">.
+func parseKML(data []byte) ([]ImportRow, error) {
+	type placemark struct {
+		Name        string `xml:"name"`
+		Description string `xml:"description"`
+		Coordinates string `xml:"Point>coordinates"`
+		Data        []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value"`
+		} `xml:"ExtendedData>Data"`
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var rows []ImportRow
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF { break }
+		if err != nil { return nil, fmt.Errorf("invalid KML: %w", err) }
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" { continue }
+		var pm placemark
+		if err := dec.DecodeElement(&pm, &start); err != nil { return nil, fmt.Errorf("invalid KML: %w", err) }
+		row := ImportRow{Row: len(rows) + 1, Name: strings.TrimSpace(pm.Name), Description: strings.TrimSpace(pm.Description)}
+		for _, d := range pm.Data {
+			switch strings.ToLower(d.Name) {
+			case "category": row.Category = strings.TrimSpace(d.Value)
+			case "city": row.City = strings.TrimSpace(d.Value)
+			}
+		}
+		parts := strings.Split(strings.TrimSpace(pm.Coordinates), ",")
+		if len(parts) >= 2 {
+			lng, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+			lat, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if err1 == nil && err2 == nil { row.Lat, row.Lng = lat, lng }
+		} else {
+			row.Errors = append(row.Errors, "placemark must be a Point")
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseCSV needs a header row naming name, lat and lng (or latitude,
+// lon, longitude) columns; description, category and city are optional.
+func parseCSV(data []byte) ([]ImportRow, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil { return nil, fmt.Errorf("invalid CSV: %w", err) }
+	if len(records) == 0 { return nil, nil }
+	col := map[string]int{}
+	aliases := map[string]string{"latitude": "lat", "lon": "lng", "long": "lng", "longitude": "lng", "title": "name"}
+	for i, h := range records[0] {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if a, ok := aliases[h]; ok { h = a }
+		col[h] = i
+	}
+	for _, required := range []string{"name", "lat", "lng"} {
+		if _, ok := col[required]; !ok { return nil, fmt.Errorf("CSV header must include %q", required) }
+	}
+	get := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) { return strings.TrimSpace(rec[i]) }
+		return ""
+	}
+	var rows []ImportRow
+	for i, rec := range records[1:] {
+		row := ImportRow{Row: i + 1, Name: get(rec, "name"), Description: get(rec, "description"), Category: get(rec, "category"), City: get(rec, "city")}
+		if lat, err := strconv.ParseFloat(get(rec, "lat"), 64); err == nil { row.Lat = lat }
+		if lng, err := strconv.ParseFloat(get(rec, "lng"), 64); err == nil { row.Lng = lng }
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// importCoordsOK rejects unparsed rows, which are left at 0,0.
+func importCoordsOK(row ImportRow) bool {
+	return validCoords(row.Lat, row.Lng) && !(row.Lat == 0 && row.Lng == 0)
+}
+
+// validateImport checks each row and collects duplicate candidates from
+// existing places (as for a single new place) and from earlier rows.
+func validateImport(rows []ImportRow, isAdmin bool) error {
+	for i := range rows {
+		row := &rows[i]
+		row.City, row.District = resolveCity(row.Lat, row.Lng, row.City)
+		if row.Name == "" { row.Errors = append(row.Errors, "name is required") }
+		if len(row.Name) > 200 { row.Errors = append(row.Errors, "name is too long") }
+		if !importCoordsOK(*row) {
+			row.Errors = append(row.Errors, "invalid coordinates")
+			continue
+		}
+		if slug, ok := resolveCategory(row.Category); ok {
+			row.Category = slug
+		} else {
+			row.Errors = append(row.Errors, fmt.Sprintf("unknown category %q", row.Category))
+		}
+
+		for _, earlier := range rows[:i] {
+			if !importCoordsOK(earlier) { continue }
+			if d := haversineKm(row.Lat, row.Lng, earlier.Lat, earlier.Lng) * 1000; d <= importDuplicateRadiusM {
+				row.Duplicates = append(row.Duplicates, ImportDuplicate{Row: earlier.Row, Name: earlier.Name, DistanceM: math.Round(d)})
+			}
+		}
+		existing, err := findDuplicatePlaces(row.Lat, row.Lng, map[string]string{"input": row.Name}, 0)
+		if err != nil { return err }
+		for _, c := range visibleDuplicates(existing, isAdmin) {
+			row.Duplicates = append(row.Duplicates, ImportDuplicate{PlaceID: c.PlaceID, Name: localizedName(c.Name, "tr"), DistanceM: c.DistanceM})
+		}
+	}
+	return nil
+}
+
+// importHandler serves POST /api/import?format=geojson|kml|csv. The file is
+// the request body or a multipart "file" field. Options: dry_run=1 and
+// skip_duplicates=1 (leave out rows with duplicate candidates). Except for
+// admins, imported places count towards placeDailyLimit.
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "POST" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	userID, claims := currentUser(r)
+	if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+	isAdmin := claims.Role == "admin"
+	q := r.URL.Query()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil { http.Error(w, "Missing file", http.StatusBadRequest); return }
+		defer file.Close()
+		body = file
+	}
+	data, err := io.ReadAll(body)
+	if err != nil { http.Error(w, "File too large (max 10MB)", http.StatusRequestEntityTooLarge); return }
+
+	var rows []ImportRow
+	switch q.Get("format") {
+	case "geojson", "json":
+		rows, err = parseGeoJSON(data)
+	case "kml":
+		rows, err = parseKML(data)
+	case "csv":
+		rows, err = parseCSV(data)
+	default:
+		http.Error(w, "format must be geojson, kml or csv", http.StatusBadRequest)
+		return
+	}
+	if err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+	if len(rows) == 0 { http.Error(w, "No places found in file", http.StatusBadRequest); return }
+	if len(rows) > maxImportRows { http.Error(w, fmt.Sprintf("Too many rows (max %d)", maxImportRows), http.StatusBadRequest); return }
+	if err := validateImport(rows, isAdmin); err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+
+	result := ImportResult{DryRun: q.Get("dry_run") != "", Total: len(rows), Rows: rows}
+	skipDuplicates := q.Get("skip_duplicates") != ""
+	for i := range rows {
+		if len(rows[i].Errors) > 0 { result.Invalid++ }
+		if len(rows[i].Duplicates) > 0 {
+			result.Duplicates++
+			rows[i].Skipped = skipDuplicates
+		}
+	}
+	if result.DryRun { json.NewEncoder(w).Encode(result); return }
+	if result.Invalid > 0 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	if !isAdmin {
+		importing := 0
+		for _, row := range rows {
+			if !row.Skipped { importing++ }
+		}
+		var today int
+		db.QueryRow("SELECT COUNT(*) FROM places WHERE creator_id = $1 AND created_at > $2", userID, time.Now().Add(-24*time.Hour)).Scan(&today)
+		if today+importing > placeDailyLimit { http.Error(w, fmt.Sprintf("Import would exceed the limit of %d new places a day", placeDailyLimit), http.StatusTooManyRequests); return }
+	}
+
+	err = withTx(func(tx *sql.Tx) error {
+		for i := range rows {
+			row := &rows[i]
+			if row.Skipped { continue }
+			nameJSON, _ := json.Marshal(translateContent(row.Name))
+			descJSON, _ := json.Marshal(translateContent(row.Description))
+			err := tx.QueryRow("INSERT INTO places (name, description, lat, lng, category, city, district, image_url, status, creator_id) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), '', 'pending', $8) RETURNING id",
+				string(nameJSON), string(descJSON), row.Lat, row.Lng, row.Category, row.City, row.District, userID).Scan(&row.PlaceID)
+			if err != nil { return err }
+			snapshot, _, err := loadPlaceSnapshot(tx, row.PlaceID, false)
+			if err != nil { return err }
+			if _, err := savePlaceRevision(tx, row.PlaceID, userID, "create", 0, nil, snapshot); err != nil { return err }
+			result.Created++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Import: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
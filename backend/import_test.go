@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Exported files must import back with the same names, positions, category
+// and city.
+func TestExportImportRoundTrip(t *testing.T) {
+	places := []Place{
+		{ID: 1, Name: map[string]string{"tr": "Ayasofya", "en": "Hagia Sophia"}, Description: map[string]string{"tr": "Müze & cami"}, Lat: 41.0086, Lng: 28.9802, Category: "historical", City: "İstanbul"},
+		{ID: 2, Name: map[string]string{"tr": "Efes Antik Kenti"}, Lat: 37.939, Lng: 27.341, Category: "historical", City: "İzmir"},
+	}
+	parsers := map[string]func([]byte) ([]ImportRow, error){"kml": parseKML, "geojson": parseGeoJSON}
+	for format, parse := range parsers {
+		var buf bytes.Buffer
+		pw := exportFormats[format].writer(&buf)
+		if err := pw.begin("Yerler"); err != nil { t.Fatal(err) }
+		for _, p := range places {
+			if err := pw.place(p, "tr"); err != nil { t.Fatal(err) }
+		}
+		if err := pw.end(); err != nil { t.Fatal(err) }
+
+		rows, err := parse(buf.Bytes())
+		if err != nil { t.Fatalf("%s: %v", format, err) }
+		if len(rows) != len(places) { t.Fatalf("%s: got %d rows, want %d:\n%s", format, len(rows), len(places), buf.String()) }
+		for i, p := range places {
+			row := rows[i]
+			want := ImportRow{Row: i + 1, Name: p.Name["tr"], Description: p.Description["tr"], Lat: p.Lat, Lng: p.Lng, Category: p.Category, City: p.City}
+			if row.Row != want.Row || row.Name != want.Name || row.Description != want.Description || row.Lat != want.Lat || row.Lng != want.Lng ||
+				row.Category != want.Category || row.City != want.City || len(row.Errors) > 0 {
+				t.Errorf("%s row %d = %+v, want %+v", format, i+1, row, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Leaderboards ---
+//
+// Leaderboards are computed from the points ledger so they can be cut by
+// time window and by the city/category of the place an award relates to.
+// Each (window, city, category) ranking is cached for leaderboardCacheTTL.
+
+var leaderboardCacheTTL = envDuration("LEADERBOARD_CACHE_TTL", time.Minute)
+
+// Every ranking holds all scoring users, so the cache is dropped wholesale
+// when it reaches this many scopes.
+const leaderboardCacheMaxKeys = 256
+
+type LeaderboardEntry struct {
+	Position    int             `json:"position"`
+	ID          int             `json:"id"`
+	Username    string          `json:"username"`
+	AvatarURL   string          `json:"avatar_url"`
+	Avatars     *AvatarVariants `json:"avatars,omitempty"`
+	Points      int             `json:"points"`       // Within the window and scope
+	TotalPoints int             `json:"total_points"` // All-time balance, drives Rank
+	Rank        *RankInfo       `json:"rank"`
+}
+
+type Leaderboard struct {
+	Window   string             `json:"window"`
+	City     string             `json:"city,omitempty"`
+	Category string             `json:"category,omitempty"`
+	Total    int                `json:"total"`
+	Entries  []LeaderboardEntry `json:"entries"`
+	Me       *LeaderboardEntry  `json:"me,omitempty"` // Caller's own row, even outside the page
+}
+
+type cachedRanking struct {
+	entries []LeaderboardEntry
+	expires time.Time
+}
+
+var leaderboardCache = struct {
+	sync.Mutex
+	m map[string]cachedRanking
+}{m: map[string]cachedRanking{}}
+
+// ledgerPlaceSQL resolves the place a ledger row relates to, directly or
+// through the comment it was awarded for.
+const ledgerPlaceSQL = `CASE l.ref_type WHEN 'place' THEN l.ref_id WHEN 'comment' THEN (SELECT c.place_id FROM comments c WHERE c.id = l.ref_id) END`
+
+// windowStart maps a window name to the earliest ledger timestamp counted.
+func windowStart(window string) (time.Time, bool) {
+	now := time.Now()
+	switch window {
+	case "week":
+		// Weeks start on Monday
+		offset := (int(now.Weekday()) + 6) % 7
+		y, m, d := now.AddDate(0, 0, -offset).Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), true
+	case "month":
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()), true
+	case "all", "":
+		return time.Time{}, true
+	}
+	return time.Time{}, false
+}
+
+// computeRanking ranks every user with positive points in the scope. Ties
+// go to whoever reached their score first, then to the lower user ID.
+func computeRanking(since time.Time, city, category string) ([]LeaderboardEntry, error) {
+	query := `
+		SELECT u.id, u.username, COALESCE(u.avatar_url, ''), u.points, s.pts
+		FROM (
+			SELECT l.user_id, SUM(l.points) AS pts, MAX(l.created_at) AS last_at
+			FROM points_ledger l
+			LEFT JOIN places p ON p.id = ` + ledgerPlaceSQL + `
+			WHERE l.created_at >= $1`
+	args := []interface{}{since}
+	if city != "" {
+		args = append(args, city)
+		query += fmt.Sprintf(" AND p.city = $%d", len(args))
+	}
+	if category != "" {
+		args = append(args, category)
+		query += fmt.Sprintf(" AND p.category = $%d", len(args))
+	}
+	query += `
+			GROUP BY l.user_id HAVING SUM(l.points) > 0
+		) s JOIN users u ON u.id = s.user_id
+		ORDER BY s.pts DESC, s.last_at ASC, u.id ASC`
+	rows, err := db.Query(query, args...)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	entries := []LeaderboardEntry{}
+	for rows.Next() {
+		var e LeaderboardEntry
+		rows.Scan(&e.ID, &e.Username, &e.AvatarURL, &e.TotalPoints, &e.Points)
+		e.Position = len(entries) + 1
+		e.AvatarURL, e.Avatars = userAvatar(e.ID, e.AvatarURL)
+		e.Rank = rankInfo(e.TotalPoints)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ranking returns the cached ranking for the scope, recomputing it once
+// the cache entry expires.
+func ranking(window string, since time.Time, city, category string) ([]LeaderboardEntry, error) {
+	key := window + "|" + city + "|" + category
+	leaderboardCache.Lock()
+	cached, ok := leaderboardCache.m[key]
+	leaderboardCache.Unlock()
+	if ok && time.Now().Before(cached.expires) { return cached.entries, nil }
+
+	entries, err := computeRanking(since, city, category)
+	if err != nil { return nil, err }
+	leaderboardCache.Lock()
+	if len(leaderboardCache.m) >= leaderboardCacheMaxKeys { leaderboardCache.m = map[string]cachedRanking{} }
+	leaderboardCache.m[key] = cachedRanking{entries: entries, expires: time.Now().Add(leaderboardCacheTTL)}
+	leaderboardCache.Unlock()
+	return entries, nil
+}
+
+// leaderboardHandler serves GET /api/leaderboard with optional
+// window=week|month|all, city, category, limit (max 100) and offset.
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "GET" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	q := r.URL.Query()
+	window := q.Get("window")
+	if window == "" { window = "all" }
+	since, ok := windowStart(window)
+	if !ok { http.Error(w, "Invalid window", http.StatusBadRequest); return }
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 { limit = 10 }
+	if limit > 100 { limit = 100 }
+	offset, err := strconv.Atoi(q.Get("offset"))
+	if err != nil || offset < 0 { offset = 0 }
+
+	// Only known scopes, so the cache can't be grown with made-up ones
+	city := q.Get("city")
+	if city != "" {
+		// Stored spelling, which may be free text for places outside the gazetteer
+		if err := db.QueryRow("SELECT city FROM places WHERE status = 'approved' AND LOWER(city) = LOWER($1) ORDER BY city = $1 DESC LIMIT 1", strings.TrimSpace(city)).Scan(&city); err != nil { http.Error(w, "Unknown city", http.StatusBadRequest); return }
+	}
+	category := q.Get("category")
+	if category != "" {
+		if category, ok = resolveCategory(category); !ok { http.Error(w, "Unknown category", http.StatusBadRequest); return }
+	}
+	entries, err := ranking(window, since, city, category)
+	if err != nil {
+		log.Printf("Leaderboard: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	lb := Leaderboard{Window: window, City: city, Category: category, Total: len(entries), Entries: []LeaderboardEntry{}}
+	if offset < len(entries) {
+		end := offset + limit
+		if end > len(entries) { end = len(entries) }
+		lb.Entries = entries[offset:end]
+	}
+	if userID, _ := currentUser(r); userID > 0 {
+		for i := range entries {
+			if entries[i].ID == userID { lb.Me = &entries[i]; break }
+		}
+	}
+	json.NewEncoder(w).Encode(lb)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Place lists ---
+//
+// Named, ordered collections of places with a note per entry. The owner
+// can invite collaborators, who may edit entries but not the list itself.
+// Public lists can be viewed and followed by anyone.
+
+const maxListEntries = 500
+
+var errListFull = fmt.Errorf("a list can hold at most %d places", maxListEntries)
+var errListOrder = errors.New("place_ids must list every entry exactly once")
+var errListMethod = errors.New("method not allowed")
+
+type PlaceList struct {
+	ID            int         `json:"id"`
+	OwnerID       int         `json:"owner_id"`
+	Owner         string      `json:"owner"`
+	Title         string      `json:"title"`
+	Description   string      `json:"description"`
+	Visibility    string      `json:"visibility"` // 'private' or 'public'
+	EntryCount    int         `json:"entry_count"`
+	FollowerCount int         `json:"follower_count"`
+	Following     bool        `json:"following"`
+	CanEdit       bool        `json:"can_edit"`
+	Collaborators []string    `json:"collaborators,omitempty"`
+	Entries       []ListEntry `json:"entries,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+// ListEntry is a Place plus its position and note within a list.
+type ListEntry struct {
+	Place
+	Position int       `json:"position"`
+	Note     string    `json:"note"`
+	AddedBy  string    `json:"added_by"`
+	AddedAt  time.Time `json:"added_at"`
+}
+
+func initListTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS lists (
+		id SERIAL PRIMARY KEY,
+		owner_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		title TEXT NOT NULL,
+		description TEXT DEFAULT '',
+		visibility TEXT NOT NULL DEFAULT 'private',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS list_entries (
+		list_id INT NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+		place_id INT NOT NULL REFERENCES places(id) ON DELETE CASCADE,
+		position INT NOT NULL,
+		note TEXT DEFAULT '',
+		added_by INT REFERENCES users(id) ON DELETE SET NULL,
+		added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (list_id, place_id)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS list_collaborators (
+		list_id INT NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (list_id, user_id)
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS list_follows (
+		list_id INT NOT NULL REFERENCES lists(id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (list_id, user_id)
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS lists_owner ON lists (owner_id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS list_collaborators_user ON list_collaborators (user_id)")
+}
+
+// listSelectSQL selects list summaries as seen by the user in $1.
+const listSelectSQL = `SELECT l.id, l.owner_id, u.username, l.title, COALESCE(l.description, ''), l.visibility, l.created_at, l.updated_at,
+		(SELECT COUNT(*) FROM list_entries e JOIN places p ON p.id = e.place_id WHERE e.list_id = l.id AND (p.status = 'approved' OR p.creator_id = $1)),
+		(SELECT COUNT(*) FROM list_follows f WHERE f.list_id = l.id),
+		EXISTS (SELECT 1 FROM list_follows f WHERE f.list_id = l.id AND f.user_id = $1),
+		l.owner_id = $1 OR EXISTS (SELECT 1 FROM list_collaborators c WHERE c.list_id = l.id AND c.user_id = $1)
+	FROM lists l JOIN users u ON u.id = l.owner_id`
+
+func scanLists(rows *sql.Rows) []PlaceList {
+	lists := []PlaceList{}
+	for rows.Next() {
+		var l PlaceList
+		rows.Scan(&l.ID, &l.OwnerID, &l.Owner, &l.Title, &l.Description, &l.Visibility, &l.CreatedAt, &l.UpdatedAt, &l.EntryCount, &l.FollowerCount, &l.Following, &l.CanEdit)
+		lists = append(lists, l)
+	}
+	return lists
+}
+
+// loadList fetches a list with entries and collaborators, or sql.ErrNoRows
+// when it doesn't exist or userID may not see it. Entries whose place isn't
+// approved are left out except for its creator and admins.
+func loadList(id, userID int, isAdmin bool) (*PlaceList, error) {
+	rows, err := db.Query(listSelectSQL+" WHERE l.id = $2", userID, id)
+	if err != nil { return nil, err }
+	lists := scanLists(rows)
+	rows.Close()
+	if len(lists) == 0 { return nil, sql.ErrNoRows }
+	l := &lists[0]
+	if l.Visibility != "public" && !l.CanEdit && !isAdmin { return nil, sql.ErrNoRows }
+
+	rows, err = db.Query(`SELECT p.id, p.name, p.description, p.lat, p.lng, p.category, p.city, COALESCE(p.image_url, ''), p.status,
+			EXISTS (SELECT 1 FROM favorites f WHERE f.place_id = p.id AND f.user_id = $2),
+			e.position, COALESCE(e.note, ''), COALESCE(u.username, ''), e.added_at
+		FROM list_entries e JOIN places p ON p.id = e.place_id LEFT JOIN users u ON u.id = e.added_by
+		WHERE e.list_id = $1 AND (p.status = 'approved' OR p.creator_id = $2 OR $3) ORDER BY e.position, e.added_at`, id, userID, isAdmin)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	l.Entries = []ListEntry{}
+	for rows.Next() {
+		var e ListEntry
+		var nameJSON, descJSON []byte
+		rows.Scan(&e.ID, &nameJSON, &descJSON, &e.Lat, &e.Lng, &e.Category, &e.City, &e.ImageURL, &e.Status, &e.IsFavorite, &e.Position, &e.Note, &e.AddedBy, &e.AddedAt)
+		json.Unmarshal(nameJSON, &e.Name)
+		json.Unmarshal(descJSON, &e.Description)
+		e.Images = imageVariants(e.ImageURL)
+		l.Entries = append(l.Entries, e)
+	}
+	collabs, err := db.Query("SELECT u.username FROM list_collaborators c JOIN users u ON u.id = c.user_id WHERE c.list_id = $1 ORDER BY c.created_at", id)
+	if err != nil { return nil, err }
+	defer collabs.Close()
+	l.Collaborators = []string{}
+	for collabs.Next() {
+		var name string
+		collabs.Scan(&name)
+		l.Collaborators = append(l.Collaborators, name)
+	}
+	return l, nil
+}
+
+func validateList(l *PlaceList) string {
+	l.Title = strings.TrimSpace(l.Title)
+	if l.Title == "" || len(l.Title) > 200 { return "Title is required (max 200 characters)" }
+	if len(l.Description) > 5000 { return "Description is too long" }
+	if l.Visibility == "" { l.Visibility = "private" }
+	if l.Visibility != "private" && l.Visibility != "public" { return "Invalid visibility" }
+	return ""
+}
+
+func touchList(tx *sql.Tx, id int) error {
+	_, err := tx.Exec("UPDATE lists SET updated_at = CURRENT_TIMESTAMP WHERE id = $1", id)
+	return err
+}
+
+// listsHandler serves /api/lists:
+//   GET                        lists the caller owns or collaborates on
+//   GET    ?user_id=           someone's public lists
+//   GET    ?followed=1         lists the caller follows
+//   GET    ?id=                one list with entries
+//   POST                       create {"title", "description", "visibility"}
+//   PUT    ?id=                update title/description/visibility (owner)
+//   DELETE ?id=                delete (owner or admin)
+//   POST   ?action=add&id=     add {"place_id", "note"} at the end (editors)
+//   PUT    ?action=entry&id=   change an entry's note {"place_id", "note"} (editors)
+//   DELETE ?action=remove&id=&place_id=   (editors)
+//   PUT    ?action=reorder&id= {"place_ids": [...]} in the new order (editors)
+//   POST   ?action=invite&id=  {"username"} adds a collaborator (owner)
+//   DELETE ?action=collaborator&id=&username=   owner removes, or a collaborator leaves
+//   POST | DELETE ?action=follow&id=   follow / unfollow a visible list
+func listsHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	userID, claims := currentUser(r)
+	isAdmin := claims != nil && claims.Role == "admin"
+	q := r.URL.Query()
+	action := q.Get("action")
+
+	if q.Get("id") == "" {
+		switch {
+		case r.Method == "GET" && q.Get("user_id") != "":
+			owner, err := strconv.Atoi(q.Get("user_id"))
+			if err != nil { http.Error(w, "Invalid user ID", http.StatusBadRequest); return }
+			rows, err := db.Query(listSelectSQL+" WHERE l.owner_id = $2 AND l.visibility = 'public' ORDER BY l.updated_at DESC", userID, owner)
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			defer rows.Close()
+			json.NewEncoder(w).Encode(scanLists(rows))
+		case r.Method == "GET":
+			if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+			where := " WHERE l.owner_id = $1 OR EXISTS (SELECT 1 FROM list_collaborators c WHERE c.list_id = l.id AND c.user_id = $1)"
+			if q.Get("followed") != "" {
+				where = " WHERE l.visibility = 'public' AND EXISTS (SELECT 1 FROM list_follows f WHERE f.list_id = l.id AND f.user_id = $1)"
+			}
+			rows, err := db.Query(listSelectSQL+where+" ORDER BY l.updated_at DESC", userID)
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			defer rows.Close()
+			json.NewEncoder(w).Encode(scanLists(rows))
+		case r.Method == "POST":
+			if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+			var l PlaceList
+			if err := json.NewDecoder(r.Body).Decode(&l); err != nil { http.Error(w, "Invalid request", http.StatusBadRequest); return }
+			if msg := validateList(&l); msg != "" { http.Error(w, msg, http.StatusBadRequest); return }
+			var id int
+			if err := db.QueryRow("INSERT INTO lists (owner_id, title, description, visibility) VALUES ($1, $2, $3, $4) RETURNING id", userID, l.Title, l.Description, l.Visibility).Scan(&id); err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			created, err := loadList(id, userID, isAdmin)
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(created)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.Atoi(q.Get("id"))
+	if err != nil { http.Error(w, "Invalid list ID", http.StatusBadRequest); return }
+	l, err := loadList(id, userID, isAdmin)
+	if err == sql.ErrNoRows { http.Error(w, "List not found", http.StatusNotFound); return }
+	if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+	if r.Method == "GET" { json.NewEncoder(w).Encode(l); return }
+	if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+	isOwner := l.OwnerID == userID
+
+	switch {
+	case action == "" && r.Method == "PUT":
+		if !isOwner { http.Error(w, "Forbidden", http.StatusForbidden); return }
+		var req PlaceList
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "Invalid request", http.StatusBadRequest); return }
+		if msg := validateList(&req); msg != "" { http.Error(w, msg, http.StatusBadRequest); return }
+		_, err = db.Exec("UPDATE lists SET title = $1, description = $2, visibility = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4", req.Title, req.Description, req.Visibility, id)
+	case action == "" && r.Method == "DELETE":
+		if !isOwner && !isAdmin { http.Error(w, "Forbidden", http.StatusForbidden); return }
+		db.Exec("DELETE FROM lists WHERE id = $1", id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case action == "follow" && (r.Method == "POST" || r.Method == "DELETE"):
+		if r.Method == "POST" {
+			if l.Visibility != "public" { http.Error(w, "Only public lists can be followed", http.StatusBadRequest); return }
+			_, err = db.Exec("INSERT INTO list_follows (list_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", id, userID)
+		} else {
+			_, err = db.Exec("DELETE FROM list_follows WHERE list_id = $1 AND user_id = $2", id, userID)
+		}
+	case action == "invite" && r.Method == "POST":
+		if !isOwner { http.Error(w, "Forbidden", http.StatusForbidden); return }
+		var req struct { Username string `json:"username"` }
+		json.NewDecoder(r.Body).Decode(&req)
+		var invitee int
+		if err := db.QueryRow("SELECT id FROM users WHERE username = $1", req.Username).Scan(&invitee); err != nil { http.Error(w, "User not found", http.StatusNotFound); return }
+		if invitee == userID { http.Error(w, "You already own this list", http.StatusBadRequest); return }
+		_, err = db.Exec("INSERT INTO list_collaborators (list_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", id, invitee)
+	case action == "collaborator" && r.Method == "DELETE":
+		var target int
+		if err := db.QueryRow("SELECT id FROM users WHERE username = $1", q.Get("username")).Scan(&target); err != nil { http.Error(w, "User not found", http.StatusNotFound); return }
+		if !isOwner && target != userID { http.Error(w, "Forbidden", http.StatusForbidden); return }
+		_, err = db.Exec("DELETE FROM list_collaborators WHERE list_id = $1 AND user_id = $2", id, target)
+	case action == "add" || action == "entry" || action == "remove" || action == "reorder":
+		if !l.CanEdit { http.Error(w, "Forbidden", http.StatusForbidden); return }
+		status, msg := editListEntries(r, action, id, userID, l)
+		if msg != "" { http.Error(w, msg, status); return }
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+	updated, err := loadList(id, userID, isAdmin)
+	if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+	json.NewEncoder(w).Encode(updated)
+}
+
+// editListEntries applies an entry action for an editor of list l.
+func editListEntries(r *http.Request, action string, id, userID int, l *PlaceList) (int, string) {
+	var req struct {
+		PlaceID  int    `json:"place_id"`
+		Note     string `json:"note"`
+		PlaceIDs []int  `json:"place_ids"`
+	}
+	if r.Method != "DELETE" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { return http.StatusBadRequest, "Invalid request" }
+	}
+	if len(req.Note) > 2000 { return http.StatusBadRequest, "Note is too long" }
+	err := withTx(func(tx *sql.Tx) error {
+		switch {
+		case action == "add" && r.Method == "POST":
+			if len(l.Entries) >= maxListEntries { return errListFull }
+			res, err := tx.Exec(`INSERT INTO list_entries (list_id, place_id, position, note, added_by)
+				SELECT $1, p.id, (SELECT COALESCE(MAX(position), -1) + 1 FROM list_entries WHERE list_id = $1), $3, $4
+				FROM places p WHERE p.id = $2 AND p.status = 'approved'
+				ON CONFLICT (list_id, place_id) DO UPDATE SET note = EXCLUDED.note`, id, req.PlaceID, req.Note, userID)
+			if err != nil { return err }
+			if n, _ := res.RowsAffected(); n == 0 { return errUnknownPlace }
+		case action == "entry" && r.Method == "PUT":
+			if _, err := tx.Exec("UPDATE list_entries SET note = $1 WHERE list_id = $2 AND place_id = $3", req.Note, id, req.PlaceID); err != nil { return err }
+		case action == "remove" && r.Method == "DELETE":
+			placeID, _ := strconv.Atoi(r.URL.Query().Get("place_id"))
+			if _, err := tx.Exec("DELETE FROM list_entries WHERE list_id = $1 AND place_id = $2", id, placeID); err != nil { return err }
+		case action == "reorder" && r.Method == "PUT":
+			if len(req.PlaceIDs) != len(l.Entries) { return errListOrder }
+			seen := map[int]bool{}
+			for pos, placeID := range req.PlaceIDs {
+				if seen[placeID] { return errListOrder }
+				seen[placeID] = true
+				res, err := tx.Exec("UPDATE list_entries SET position = $1 WHERE list_id = $2 AND place_id = $3", pos, id, placeID)
+				if err != nil { return err }
+				if n, _ := res.RowsAffected(); n == 0 { return errListOrder }
+			}
+		default:
+			return errListMethod
+		}
+		return touchList(tx, id)
+	})
+	switch err {
+	case nil:
+		return http.StatusOK, ""
+	case errUnknownPlace, errListFull, errListOrder:
+		return http.StatusBadRequest, err.Error()
+	case errListMethod:
+		return http.StatusMethodNotAllowed, "Method not allowed"
+	}
+	return http.StatusInternalServerError, "Database error"
+}
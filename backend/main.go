@@ -4,11 +4,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -16,23 +14,29 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	_ "github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 )
 
 // --- Structs ---
 
 type Place struct {
-	ID          int               `json:"id"`
-	Name        map[string]string `json:"name"`        // JSONB
-	Description map[string]string `json:"description"` // JSONB
-	Lat         float64           `json:"lat"`
-	Lng         float64           `json:"lng"`
-	Category    string            `json:"category"`
-	City        string            `json:"city"`
-	ImageURL    string            `json:"imageUrl"`
-	Status      string            `json:"status"` // 'pending' or 'approved'
-	IsFavorite  bool              `json:"is_favorite"`
+	ID               int               `json:"id"`
+	Name             map[string]string `json:"name"`        // JSONB
+	Description      map[string]string `json:"description"` // JSONB
+	Lat              float64           `json:"lat"`
+	Lng              float64           `json:"lng"`
+	Category         string            `json:"category"`
+	City             string            `json:"city"`
+	District         string            `json:"district,omitempty"` // Derived from lat/lng
+	ImageURL         string            `json:"imageUrl"`
+	Images           *ImageVariants    `json:"images,omitempty"`            // Derived from ImageURL
+	Status           string            `json:"status"`                      // See moderation.go
+	ModerationReason string            `json:"moderation_reason,omitempty"` // Shown to the creator and admins
+	IsFavorite       bool              `json:"is_favorite"`
+	TravelKm         *float64          `json:"travel_km,omitempty"` // Nearby search with ?travel=
+	TravelMinutes    *float64          `json:"travel_minutes,omitempty"`
+	OpenNow          *bool             `json:"open_now,omitempty"`   // Derived from OpeningHours and Timezone
+	UpdatedAt        *time.Time        `json:"updated_at,omitempty"` // Last revision; unset for places older than revisions
+	PlaceAttributes
 }
 
 type PlaceRequest struct {
@@ -43,6 +47,7 @@ type PlaceRequest struct {
 	Category    string  `json:"category"`
 	City        string  `json:"city"`
 	ImageURL    string  `json:"imageUrl"`
+	PlaceAttributes
 }
 
 type Comment struct {
@@ -60,8 +65,10 @@ type User struct {
 	Role      string `json:"role"`
 	Email     string `json:"email"`
 	Bio       string `json:"bio"`
-	AvatarURL string `json:"avatar_url"`
-	Points    int    `json:"points"`
+	AvatarURL string          `json:"avatar_url"`
+	Avatars   *AvatarVariants `json:"avatars,omitempty"`
+	Points    int             `json:"points"`
+	Rank      *RankInfo       `json:"rank,omitempty"`
 }
 
 type Credentials struct {
@@ -179,13 +186,29 @@ func initDB() {
 	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS image_url TEXT")
 	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS city TEXT")
 	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS category TEXT")
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS district TEXT")
 	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS creator_id INT REFERENCES users(id) ON DELETE SET NULL")
 	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS price DOUBLE PRECISION DEFAULT 0")
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS created_at TIMESTAMP")
+	db.Exec("ALTER TABLE places ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP")
 	db.Exec("ALTER TABLE comments ADD COLUMN IF NOT EXISTS user_id INT REFERENCES users(id) ON DELETE CASCADE")
 	db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT DEFAULT ''")
 	db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS bio TEXT DEFAULT ''")
 	db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url TEXT DEFAULT ''")
 	db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS points INT DEFAULT 0")
+
+	initUploadTables()
+	initPointsTables()
+	initBadgeTables()
+	initCheckinTables()
+	initAbuseTables()
+	initRouteTables()
+	initListTables()
+	initDuplicateTables()
+	initCategoryTables()
+	initAttributeColumns()
+	initRevisionTables()
+	initModerationTables()
 }
 
 func enableCors(w http.ResponseWriter) {
@@ -247,6 +270,18 @@ func validateToken(tokenStr string) (*Claims, error) {
 	return claims, nil
 }
 
+// currentUser resolves the bearer token on the request to a user ID.
+// It returns 0 and nil claims when the header is missing or invalid.
+func currentUser(r *http.Request) (int, *Claims) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" { return 0, nil }
+	claims, err := validateToken(strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil { return 0, nil }
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE username=$1", claims.Username).Scan(&userID); err != nil { return 0, nil }
+	return userID, claims
+}
+
 func adminOnly(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		enableCors(w)
@@ -260,27 +295,6 @@ func adminOnly(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func uploadHandler(w http.ResponseWriter, r *http.Request) {
-	enableCors(w)
-	if r.Method == "OPTIONS" { return }
-	if r.Method != "POST" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
-	r.ParseMultipartForm(10 << 20)
-	file, handler, err := r.FormFile("image")
-	if err != nil { http.Error(w, "Error retrieving file", http.StatusBadRequest); return }
-	defer file.Close()
-	ext := strings.ToLower(filepath.Ext(handler.Filename))
-	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" && ext != ".webp" { http.Error(w, "Invalid file type", http.StatusBadRequest); return }
-	filename := fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-	filePath := filepath.Join("uploads", filename)
-	dst, err := os.Create(filePath)
-	if err != nil { http.Error(w, "Error saving file", http.StatusInternalServerError); return }
-	defer dst.Close()
-	if _, err := io.Copy(dst, file); err != nil { http.Error(w, "Error saving file", http.StatusInternalServerError); return }
-	fileURL := fmt.Sprintf("/uploads/%s", filename)
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"url": fileURL})
-}
-
 func translateContent(text string) map[string]string {
 	result := make(map[string]string)
 	result["tr"] = text
@@ -308,39 +322,77 @@ func placesHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		// Single place; merged IDs redirect to the surviving place
+		if idStr := r.URL.Query().Get("id"); idStr != "" {
+			id, err := strconv.Atoi(idStr)
+			if err != nil { http.Error(w, "Invalid place ID", http.StatusBadRequest); return }
+			if placeRedirect(w, r, id) { return }
+			var p Place
+			var nameJSON, descJSON []byte
+			var attrs placeAttributeScan
+			err = db.QueryRow(`SELECT p.id, p.name, p.description, p.lat, p.lng, p.category, p.city, COALESCE(p.district, ''), COALESCE(p.image_url, ''), p.status,
+				EXISTS(SELECT 1 FROM favorites f WHERE f.place_id = p.id AND f.user_id = $1), p.updated_at, `+placeAttributeColumns+`
+				FROM places p WHERE p.id = $2 AND p.status = 'approved'`, userID, id).Scan(append([]interface{}{&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.District, &p.ImageURL, &p.Status, &p.IsFavorite, &p.UpdatedAt}, attrs.dest()...)...)
+			if err == sql.ErrNoRows { http.Error(w, "Place not found", http.StatusNotFound); return }
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			json.Unmarshal(nameJSON, &p.Name)
+			json.Unmarshal(descJSON, &p.Description)
+			p.Images = imageVariants(p.ImageURL)
+			attrs.apply(&p, time.Now())
+			json.NewEncoder(w).Encode(p)
+			return
+		}
+
 		latStr := r.URL.Query().Get("lat")
 		lngStr := r.URL.Query().Get("lng")
 		radiusStr := r.URL.Query().Get("radius")
 		
 		query := `
-			SELECT p.id, p.name, p.description, p.lat, p.lng, p.category, p.city, COALESCE(p.image_url, '') as image_url, p.status,
-			EXISTS(SELECT 1 FROM favorites f WHERE f.place_id = p.id AND f.user_id = $1) as is_favorite
+			SELECT p.id, p.name, p.description, p.lat, p.lng, p.category, p.city, COALESCE(p.district, ''), COALESCE(p.image_url, '') as image_url, p.status,
+			EXISTS(SELECT 1 FROM favorites f WHERE f.place_id = p.id AND f.user_id = $1) as is_favorite, p.updated_at, ` + placeAttributeColumns + `
 			FROM places p WHERE p.status = 'approved'`
+		order := " ORDER BY id DESC"
 		
 		args := []interface{}{userID}
 		if latStr != "" && lngStr != "" && radiusStr != "" {
 			query = `
-				SELECT id, name, description, lat, lng, category, city, image_url, status, is_favorite
+				SELECT id, name, description, lat, lng, category, city, COALESCE(district, ''), image_url, status, is_favorite, updated_at, ` + placeAttributeColumns + `
 				FROM (
 					SELECT p.*, (6371 * acos(cos(radians($2)) * cos(radians(lat)) * cos(radians(lng) - radians($3)) + sin(radians($2)) * sin(radians(lat)))) AS distance,
 					EXISTS(SELECT 1 FROM favorites f WHERE f.place_id = p.id AND f.user_id = $1) as is_favorite
 					FROM places p WHERE status = 'approved'
-				) AS p WHERE distance < $4 ORDER BY distance ASC`
+				) AS p WHERE distance < $4`
+			order = " ORDER BY distance ASC"
 			args = append(args, latStr, lngStr, radiusStr)
-		} else { query += " ORDER BY id DESC" }
+		}
+		conds, args, msg := attributeFilters(r.URL.Query(), args)
+		if msg != "" { http.Error(w, msg, http.StatusBadRequest); return }
+		for _, c := range conds { query += " AND " + c }
+		query += order
 
 		rows, err := db.Query(query, args...)
 		if err != nil { http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError); return }
 		defer rows.Close()
+		openOnly := r.URL.Query().Get("open_now") == "1"
+		now := time.Now()
 		var places []Place
 		for rows.Next() {
 			var p Place
 			var nameJSON, descJSON []byte
-			rows.Scan(&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.ImageURL, &p.Status, &p.IsFavorite)
+			var attrs placeAttributeScan
+			rows.Scan(append([]interface{}{&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.District, &p.ImageURL, &p.Status, &p.IsFavorite, &p.UpdatedAt}, attrs.dest()...)...)
+			attrs.apply(&p, now)
+			// Places without known hours don't count as open
+			if openOnly && (p.OpenNow == nil || !*p.OpenNow) { continue }
 			json.Unmarshal(nameJSON, &p.Name)
 			json.Unmarshal(descJSON, &p.Description)
+			p.Images = imageVariants(p.ImageURL)
 			places = append(places, p)
 		}
+		if profile := r.URL.Query().Get("travel"); profile != "" && latStr != "" && lngStr != "" {
+			if !validProfile(profile) { http.Error(w, "Invalid travel profile", http.StatusBadRequest); return }
+			places = withTravelTimes(places, profile, latStr, lngStr, r.URL.Query().Get("max_minutes"))
+		}
 		json.NewEncoder(w).Encode(places)
 	} else if r.Method == "POST" {
 		authHeader := r.Header.Get("Authorization")
@@ -353,43 +405,66 @@ func placesHandler(w http.ResponseWriter, r *http.Request) {
 		var pr PlaceRequest
 		if err := json.NewDecoder(r.Body).Decode(&pr); err != nil { http.Error(w, "Invalid body", http.StatusBadRequest); return }
 		
-		// Normalize City Name (Title Case with Turkish support)
-		pr.City = cases.Title(language.Turkish).String(pr.City)
+		category, ok := resolveCategory(pr.Category)
+		if !ok { http.Error(w, "Unknown category", http.StatusBadRequest); return }
+		pr.Category = category
+		if creatorID > 0 {
+			var today int
+			db.QueryRow("SELECT COUNT(*) FROM places WHERE creator_id = $1 AND created_at > $2", creatorID, time.Now().Add(-24*time.Hour)).Scan(&today)
+			if today >= placeDailyLimit { http.Error(w, "Too many new places today, try again later", http.StatusTooManyRequests); return }
+		}
+		if msg := validateAttributes(&pr.PlaceAttributes); msg != "" { http.Error(w, msg, http.StatusBadRequest); return }
+
+		// Ask the client to confirm before adding a likely duplicate
+		if r.URL.Query().Get("force") != "1" {
+			duplicates, err := findDuplicatePlaces(pr.Lat, pr.Lng, map[string]string{"input": pr.Name}, 0)
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			_, claims := currentUser(r)
+			duplicates = visibleDuplicates(duplicates, claims != nil && claims.Role == "admin")
+			if len(duplicates) > 0 {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]interface{}{"error": "possible_duplicate", "duplicates": duplicates})
+				return
+			}
+		}
+
+		// City and district come from the coordinates when we can place them
+		var district string
+		pr.City, district = resolveCity(pr.Lat, pr.Lng, pr.City)
 
 		nameMap := translateContent(pr.Name)
 		descMap := translateContent(pr.Description)
 		nameJSON, _ := json.Marshal(nameMap)
 		descJSON, _ := json.Marshal(descMap)
 		status := "pending"
+		// Trusted ranks skip the moderation queue
+		if creatorID > 0 && userHasPrivilege(creatorID, privilegeAutoApprove) { status = "approved" }
 		var id int
-		var err error
-		if creatorID > 0 {
-			err = db.QueryRow("INSERT INTO places (name, description, lat, lng, category, city, image_url, status, creator_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id", string(nameJSON), string(descJSON), pr.Lat, pr.Lng, pr.Category, pr.City, pr.ImageURL, status, creatorID).Scan(&id)
-			// Award Points (+50 XP)
-			if err == nil {
-				db.Exec("UPDATE users SET points = points + 50 WHERE id = $1", creatorID)
-			}
-		} else {
-			err = db.QueryRow("INSERT INTO places (name, description, lat, lng, category, city, image_url, status) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id", string(nameJSON), string(descJSON), pr.Lat, pr.Lng, pr.Category, pr.City, pr.ImageURL, status).Scan(&id)
-		}
+		err := withTx(func(tx *sql.Tx) error {
+			args := append([]interface{}{string(nameJSON), string(descJSON), pr.Lat, pr.Lng, pr.Category, pr.City, district, pr.ImageURL, status, creatorID}, attributeArgs(pr.PlaceAttributes)...)
+			if err := tx.QueryRow("INSERT INTO places (name, description, lat, lng, category, city, district, image_url, status, creator_id, opening_hours, timezone, price_level, prices, amenities) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, NULLIF($10, 0), $11, $12, $13, $14, $15) RETURNING id", args...).Scan(&id); err != nil { return err }
+			snapshot, _, err := loadPlaceSnapshot(tx, id, false)
+			if err != nil { return err }
+			if _, err := savePlaceRevision(tx, id, creatorID, "create", 0, nil, snapshot); err != nil { return err }
+			// Otherwise points are awarded once a moderator approves the place
+			if creatorID == 0 || status != "approved" { return nil }
+			_, err = awardPoints(tx, creatorID, eventPlaceApproved, refPlace, id)
+			return err
+		})
+		if err == nil { retainUpload(pr.ImageURL) }
 		if err != nil {
 			log.Printf("Error inserting place: %v", err)
 			http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		p := Place{ID: id, Name: nameMap, Description: descMap, Lat: pr.Lat, Lng: pr.Lng, Category: pr.Category, City: pr.City, ImageURL: pr.ImageURL, Status: status}
+		p := Place{ID: id, Name: nameMap, Description: descMap, Lat: pr.Lat, Lng: pr.Lng, Category: pr.Category, City: pr.City, District: district, ImageURL: pr.ImageURL, Images: imageVariants(pr.ImageURL), Status: status, PlaceAttributes: pr.PlaceAttributes}
+		p.OpenNow = openNow(p.OpeningHours, p.Timezone, time.Now())
+		now := time.Now()
+		p.UpdatedAt = &now
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(p)
 	} else if r.Method == "PUT" {
-		var pr PlaceRequest
-		json.NewDecoder(r.Body).Decode(&pr)
-		nameMap := translateContent(pr.Name)
-		descMap := translateContent(pr.Description)
-		nameJSON, _ := json.Marshal(nameMap)
-		descJSON, _ := json.Marshal(descMap)
-		_ = nameJSON
-		_ = descJSON
-		json.NewEncoder(w).Encode(map[string]string{"status": "Update not fully implemented in multi-language mode yet"})
+		placeEditHandler(w, r)
 	}
 }
 
@@ -407,19 +482,50 @@ func adminHandler(w http.ResponseWriter, r *http.Request) {
 			stats["pending_places"] = pendingPlaces
 			stats["total_users"] = totalUsers
 			stats["total_comments"] = totalComments
-			rows, _ := db.Query("SELECT category, COUNT(*) FROM places GROUP BY category")
-			categories := make(map[string]int)
+			// Every category appears, including empty ones
+			rows, _ := db.Query("SELECT c.slug, COUNT(p.id) FROM categories c LEFT JOIN places p ON p.category = c.slug GROUP BY c.slug")
+			byCategory := make(map[string]int)
 			for rows.Next() {
 				var cat string
 				var count int
 				rows.Scan(&cat, &count)
-				categories[cat] = count
+				byCategory[cat] = count
 			}
 			rows.Close()
-			stats["categories"] = categories
+			stats["categories"] = byCategory
+			rows, _ = db.Query("SELECT COALESCE(status, ''), COUNT(*) FROM places GROUP BY 1")
+			byStatus := make(map[string]int)
+			for rows.Next() {
+				var status string
+				var count int
+				rows.Scan(&status, &count)
+				byStatus[status] = count
+			}
+			rows.Close()
+			stats["statuses"] = byStatus
 			json.NewEncoder(w).Encode(stats)
 			return
 		}
+		if action == "upload-gc" && (r.Method == "GET" || r.Method == "POST") {
+			uploadGCAdmin(w, r)
+			return
+		}
+		if action == "point-rules" && (r.Method == "GET" || r.Method == "PUT") {
+			pointRulesAdmin(w, r)
+			return
+		}
+		if action == "flags" || action == "resolve-flag" || action == "clawback" {
+			abuseAdmin(w, r, action)
+			return
+		}
+		if action == "duplicates" || action == "merge" {
+			duplicatesAdmin(w, r, action)
+			return
+		}
+		if action == "revisions" || action == "rollback" || action == "edits" || action == "approve-edit" || action == "reject-edit" {
+			revisionsAdmin(w, r, action)
+			return
+		}
 		if r.Method == "GET" && action == "users" {
 			rows, _ := db.Query("SELECT id, username, role FROM users ORDER BY id ASC")
 			defer rows.Close()
@@ -432,26 +538,28 @@ func adminHandler(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(users)
 			return
 		}
+		// Other queues with &status=, e.g. changes_requested or archived
 		if r.Method == "GET" && action == "pending" {
-			rows, _ := db.Query("SELECT id, name, description, lat, lng, category, city, COALESCE(image_url, '') as image_url, status FROM places WHERE status = 'pending' ORDER BY id DESC")
+			status := r.URL.Query().Get("status")
+			if status == "" { status = statusPending }
+			rows, _ := db.Query("SELECT id, name, description, lat, lng, category, city, COALESCE(image_url, '') as image_url, status, COALESCE(moderation_reason, '') FROM places WHERE status = $1 ORDER BY id DESC", status)
 			defer rows.Close()
 			var places []Place
 			for rows.Next() {
 				var p Place
 				var nameJSON, descJSON []byte
-				rows.Scan(&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.ImageURL, &p.Status)
+				rows.Scan(&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.ImageURL, &p.Status, &p.ModerationReason)
 				json.Unmarshal(nameJSON, &p.Name)
 				json.Unmarshal(descJSON, &p.Description)
+				p.Images = imageVariants(p.ImageURL)
 				places = append(places, p)
 			}
 			json.NewEncoder(w).Encode(places)
 			return
 		}
-		if r.Method == "POST" && (action == "approve" || action == "reject") {
-			var req struct { ID int `json:"id"` }
-			json.NewDecoder(r.Body).Decode(&req)
-			if action == "approve" { db.Exec("UPDATE places SET status = 'approved' WHERE id = $1", req.ID) } else { db.Exec("DELETE FROM places WHERE id = $1", req.ID) }
-			w.WriteHeader(http.StatusOK)
+		if _, ok := moderationActions[action]; ok {
+			moderationAdmin(w, r, action)
+			return
 		}
 	})(w, r)
 }
@@ -460,8 +568,12 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 	enableCors(w)
 	if r.Method == "OPTIONS" { return }
 	if r.Method == "GET" {
-		placeID := r.URL.Query().Get("place_id")
-		rows, _ := db.Query("SELECT id, place_id, content, rating, created_at FROM comments WHERE place_id = $1 ORDER BY created_at DESC", placeID)
+		placeID, _ := strconv.Atoi(r.URL.Query().Get("place_id"))
+		// Comments of hidden places are for the place's creator and admins only
+		userID, claims := currentUser(r)
+		isAdmin := claims != nil && claims.Role == "admin"
+		rows, _ := db.Query(`SELECT c.id, c.place_id, c.content, c.rating, c.created_at FROM comments c JOIN places p ON p.id = c.place_id
+			WHERE c.place_id = $1 AND (p.status = 'approved' OR p.creator_id = $2 OR $3) ORDER BY c.created_at DESC`, resolvePlaceID(placeID), userID, isAdmin)
 		defer rows.Close()
 		comments := []Comment{}
 		for rows.Next() {
@@ -480,15 +592,37 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		var c Comment
 		json.NewDecoder(r.Body).Decode(&c)
+		c.PlaceID = resolvePlaceID(c.PlaceID)
+		var status string
+		if err := db.QueryRow("SELECT COALESCE(status, '') FROM places WHERE id = $1", c.PlaceID).Scan(&status); err != nil || status != statusApproved { http.Error(w, "Place not found", http.StatusNotFound); return }
 		if userID > 0 {
-			db.QueryRow("INSERT INTO comments (place_id, content, rating, user_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at", c.PlaceID, c.Content, c.Rating, userID).Scan(&c.ID, &c.CreatedAt)
-			// Award Points (+10 XP)
-			db.Exec("UPDATE users SET points = points + 10 WHERE id = $1", userID)
+			err := withTx(func(tx *sql.Tx) error {
+				if err := tx.QueryRow("INSERT INTO comments (place_id, content, rating, user_id) VALUES ($1, $2, $3, $4) RETURNING id, created_at", c.PlaceID, c.Content, c.Rating, userID).Scan(&c.ID, &c.CreatedAt); err != nil { return err }
+				if !commentEarnsPoints(tx, userID, c) { return nil }
+				_, err := awardPoints(tx, userID, eventCommentAdded, refComment, c.ID)
+				return err
+			})
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
 		} else {
 			db.QueryRow("INSERT INTO comments (place_id, content, rating) VALUES ($1, $2, $3) RETURNING id, created_at", c.PlaceID, c.Content, c.Rating).Scan(&c.ID, &c.CreatedAt)
 		}
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(c)
+	} else if r.Method == "DELETE" {
+		userID, claims := currentUser(r)
+		if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+		commentID, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil { http.Error(w, "Invalid comment ID", http.StatusBadRequest); return }
+		var authorID int
+		if err := db.QueryRow("SELECT COALESCE(user_id, 0) FROM comments WHERE id = $1", commentID).Scan(&authorID); err != nil { http.Error(w, "Comment not found", http.StatusNotFound); return }
+		if authorID != userID && claims.Role != "admin" { http.Error(w, "Forbidden", http.StatusForbidden); return }
+		err = withTx(func(tx *sql.Tx) error {
+			if err := reversePoints(tx, refComment, commentID); err != nil { return err }
+			_, err := tx.Exec("DELETE FROM comments WHERE id = $1", commentID)
+			return err
+		})
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
@@ -504,22 +638,39 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 	err = db.QueryRow("SELECT id FROM users WHERE username=$1", claims.Username).Scan(&userID)
 	if err != nil { http.Error(w, "User not found", http.StatusNotFound); return }
 	action := r.URL.Query().Get("action")
+	if r.Method == "POST" && action == "avatar" {
+		avatarUploadHandler(w, r, userID)
+		return
+	}
 	if r.Method == "GET" {
 		if action == "places" {
-			rows, _ := db.Query("SELECT id, name, description, lat, lng, category, city, COALESCE(image_url, ''), status FROM places WHERE creator_id = $1 ORDER BY id DESC", userID)
+			rows, _ := db.Query("SELECT id, name, description, lat, lng, category, city, COALESCE(image_url, ''), status, COALESCE(moderation_reason, '') FROM places WHERE creator_id = $1 ORDER BY id DESC", userID)
 			defer rows.Close()
 			var places []Place
 			for rows.Next() {
 				var p Place
 				var nameJSON, descJSON []byte
-				rows.Scan(&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.ImageURL, &p.Status)
+				rows.Scan(&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.ImageURL, &p.Status, &p.ModerationReason)
 				json.Unmarshal(nameJSON, &p.Name)
 				json.Unmarshal(descJSON, &p.Description)
+				p.Images = imageVariants(p.ImageURL)
 				places = append(places, p)
 			}
 			json.NewEncoder(w).Encode(places)
 			return
 		} 
+		if action == "badges" {
+			list, err := userBadges(userID)
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			json.NewEncoder(w).Encode(list)
+			return
+		}
+		if action == "points" {
+			entries, err := userLedger(userID)
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			json.NewEncoder(w).Encode(entries)
+			return
+		}
 		if action == "comments" {
 			rows, _ := db.Query("SELECT c.id, c.content, c.rating, c.created_at, p.id, p.name FROM comments c JOIN places p ON c.place_id = p.id WHERE c.user_id = $1 ORDER BY c.created_at DESC", userID)
 			defer rows.Close()
@@ -537,13 +688,28 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 		var u User
 		err := db.QueryRow("SELECT id, username, role, COALESCE(email, ''), COALESCE(bio, ''), COALESCE(avatar_url, ''), points FROM users WHERE id=$1", userID).Scan(&u.ID, &u.Username, &u.Role, &u.Email, &u.Bio, &u.AvatarURL, &u.Points)
 		if err != nil { http.Error(w, "User not found", http.StatusNotFound); return }
+		u.AvatarURL, u.Avatars = userAvatar(u.ID, u.AvatarURL)
+		u.Rank = rankInfo(u.Points)
 		json.NewEncoder(w).Encode(u)
 	} else if r.Method == "PUT" {
 		var u User
 		json.NewDecoder(r.Body).Decode(&u)
+		avatarURL, err := validateAvatarURL(userID, u.AvatarURL)
+		if err != nil { http.Error(w, err.Error(), http.StatusBadRequest); return }
+		u.AvatarURL = avatarURL
+		var oldAvatar string
+		db.QueryRow("SELECT COALESCE(avatar_url, '') FROM users WHERE id=$1", userID).Scan(&oldAvatar)
 		db.Exec("UPDATE users SET email=$1, bio=$2, avatar_url=$3 WHERE id=$4", u.Email, u.Bio, u.AvatarURL, userID)
+		if oldAvatar != u.AvatarURL {
+			releaseUpload(oldAvatar)
+			retainUpload(u.AvatarURL)
+		}
+		u.ID = userID
 		u.Username = claims.Username
 		u.Role = claims.Role
+		u.AvatarURL, u.Avatars = userAvatar(u.ID, u.AvatarURL)
+		db.QueryRow("SELECT points FROM users WHERE id=$1", userID).Scan(&u.Points)
+		u.Rank = rankInfo(u.Points)
 		json.NewEncoder(w).Encode(u)
 	}
 }
@@ -581,7 +747,7 @@ func favoritesHandler(w http.ResponseWriter, r *http.Request) {
 			SELECT p.id, p.name, p.description, p.lat, p.lng, p.category, p.city, COALESCE(p.image_url, ''), p.status 
 			FROM places p 
 			JOIN favorites f ON p.id = f.place_id 
-			WHERE f.user_id = $1`, userID)
+			WHERE f.user_id = $1 AND (p.status = 'approved' OR p.creator_id = $1 OR $2)`, userID, claims.Role == "admin")
 		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
 		defer rows.Close()
 		var places []Place
@@ -591,6 +757,7 @@ func favoritesHandler(w http.ResponseWriter, r *http.Request) {
 			rows.Scan(&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.ImageURL, &p.Status)
 			json.Unmarshal(nameJSON, &p.Name)
 			json.Unmarshal(descJSON, &p.Description)
+			p.Images = imageVariants(p.ImageURL)
 			places = append(places, p)
 		}
 		json.NewEncoder(w).Encode(places)
@@ -601,12 +768,18 @@ func favoritesHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return 
 		}
-		_, err = db.Exec("INSERT INTO favorites (user_id, place_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", userID, req.PlaceID)
+		res, err := db.Exec(`INSERT INTO favorites (user_id, place_id) SELECT $1, p.id FROM places p
+			WHERE p.id = $2 AND (p.status = 'approved' OR p.creator_id = $1) ON CONFLICT DO NOTHING`, userID, resolvePlaceID(req.PlaceID))
 		if err != nil { 
 			log.Printf("Favorites POST: DB Error: %v", err)
 			http.Error(w, "Database error: " + err.Error(), http.StatusInternalServerError)
 			return 
 		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			var exists bool
+			db.QueryRow("SELECT EXISTS(SELECT 1 FROM favorites WHERE user_id = $1 AND place_id = $2)", userID, resolvePlaceID(req.PlaceID)).Scan(&exists)
+			if !exists { http.Error(w, "Place not found", http.StatusNotFound); return }
+		}
 		w.WriteHeader(http.StatusCreated)
 	} else if r.Method == "DELETE" {
 		placeIDStr := r.URL.Query().Get("place_id")
@@ -617,7 +790,7 @@ func favoritesHandler(w http.ResponseWriter, r *http.Request) {
 			return 
 		}
 		
-		_, err = db.Exec("DELETE FROM favorites WHERE user_id = $1 AND place_id = $2", userID, placeID)
+		_, err = db.Exec("DELETE FROM favorites WHERE user_id = $1 AND place_id = $2", userID, resolvePlaceID(placeID))
 		if err != nil { 
 			log.Printf("Favorites DELETE: DB Error: %v", err)
 			http.Error(w, "Database error: " + err.Error(), http.StatusInternalServerError)
@@ -627,28 +800,19 @@ func favoritesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
-	enableCors(w)
-	if r.Method != "GET" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
-
-	rows, err := db.Query("SELECT id, username, COALESCE(avatar_url, ''), points FROM users ORDER BY points DESC LIMIT 10")
-	if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
-	defer rows.Close()
-
-	var users []User
-	for rows.Next() {
-		var u User
-		rows.Scan(&u.ID, &u.Username, &u.AvatarURL, &u.Points)
-		users = append(users, u)
-	}
-	json.NewEncoder(w).Encode(users)
-}
-
 func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
 	initDB()
-	os.MkdirAll("uploads", os.ModePerm)
-	fs := http.FileServer(http.Dir("./uploads"))
-	http.Handle("/uploads/", http.StripPrefix("/uploads/", fs))
+	var err error
+	if blobs, err = newBlobStore(); err != nil { log.Fatalf("Blob store: %v", err) }
+	startUploadSweeper()
+	startRoutingGraph()
+	if err := loadGazetteer(); err != nil { log.Printf("Geocoder: %v", err) }
+	if geocoder, err = newGeocoder(); err != nil { log.Fatalf("Geocoder: %v", err) }
+	http.HandleFunc("/uploads/", serveUploadHandler)
 	http.HandleFunc("/api/upload", uploadHandler)
 	http.HandleFunc("/api/register", registerHandler)
 	http.HandleFunc("/api/login", loginHandler)
@@ -658,6 +822,19 @@ func main() {
 	http.HandleFunc("/api/user", userHandler)
 	http.HandleFunc("/api/favorites", favoritesHandler)
 	http.HandleFunc("/api/leaderboard", leaderboardHandler)
+	http.HandleFunc("/api/avatar", identiconHandler)
+	http.HandleFunc("/api/badges", badgesHandler)
+	http.HandleFunc("/api/ranks", ranksHandler)
+	http.HandleFunc("/api/checkins", checkinsHandler)
+	http.HandleFunc("/api/planner", plannerHandler)
+	http.HandleFunc("/api/routes", routesHandler)
+	http.HandleFunc("/api/lists", listsHandler)
+	http.HandleFunc("/api/export", exportHandler)
+	http.HandleFunc("/api/import", importHandler)
+	http.HandleFunc("/api/geocode", geocodeHandler)
+	http.HandleFunc("/api/categories", categoriesHandler)
+	http.HandleFunc("/api/notifications", notificationsHandler)
+	http.HandleFunc("/tiles/places/", placeTilesHandler)
 	fmt.Println("Server starting on port 8080...")
 	http.ListenAndServe(":8080", nil)
 }
\ No newline at end of file
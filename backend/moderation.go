@@ -0,0 +1,235 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Moderation ---
+//
+// A place is pending, approved, rejected, changes_requested or archived.
+// Moderators decide with a reason, and the decision, moderator and time are
+// stored on the place. Nothing is deleted: archiving is the soft delete and
+// only hides the place like any status other than approved. Points follow
+// the status: they are awarded on approval and reversed when an approved
+// place leaves it, together with the points of its comments, which come
+// back if the place is approved again. The creator is notified of every
+// decision.
+
+const (
+	statusPending          = "pending"
+	statusApproved         = "approved"
+	statusRejected         = "rejected"
+	statusChangesRequested = "changes_requested" // Back to pending when the creator edits the place
+	statusArchived         = "archived"
+)
+
+// moderationActions maps admin actions to the status they set.
+var moderationActions = map[string]string{
+	"approve":         statusApproved,
+	"reject":          statusRejected,
+	"request-changes": statusChangesRequested,
+	"archive":         statusArchived,
+}
+
+const maxModerationBatch = 200
+
+type Notification struct {
+	ID        int                    `json:"id"`
+	Kind      string                 `json:"kind"` // place_moderated or edit_reviewed
+	RefType   string                 `json:"ref_type,omitempty"`
+	RefID     int                    `json:"ref_id,omitempty"`
+	Data      map[string]interface{} `json:"data"`
+	Read      bool                   `json:"read"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// ModerationResult is one entry of a (bulk) decision response.
+type ModerationResult struct {
+	ID     int    `json:"id"`
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func initModerationTables() {
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS moderation_reason TEXT")
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS moderated_by INT REFERENCES users(id) ON DELETE SET NULL")
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS moderated_at TIMESTAMP")
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP")
+	db.Exec("ALTER TABLE comments ADD COLUMN IF NOT EXISTS points_suspended BOOLEAN NOT NULL DEFAULT false")
+	db.Exec(`CREATE TABLE IF NOT EXISTS notifications (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		ref_type TEXT,
+		ref_id INT,
+		data JSONB NOT NULL DEFAULT '{}',
+		read_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS notifications_user_idx ON notifications (user_id, id)")
+}
+
+// notify queues a notification for userID; anonymous (0) is skipped.
+func notify(tx *sql.Tx, userID int, kind, refType string, refID int, data map[string]interface{}) error {
+	if userID <= 0 { return nil }
+	b, _ := json.Marshal(data)
+	_, err := tx.Exec("INSERT INTO notifications (user_id, kind, ref_type, ref_id, data) VALUES ($1, $2, $3, $4, $5)", userID, kind, refType, refID, string(b))
+	return err
+}
+
+// moderatePlace moves a place to status, keeping points in step, and
+// notifies its creator.
+func moderatePlace(tx *sql.Tx, placeID, moderatorID int, status, reason string) error {
+	var previous string
+	var nameJSON []byte
+	var creatorID int
+	if err := tx.QueryRow("SELECT COALESCE(status, ''), name, COALESCE(creator_id, 0) FROM places WHERE id = $1 FOR UPDATE", placeID).Scan(&previous, &nameJSON, &creatorID); err != nil { return err }
+	_, err := tx.Exec(`UPDATE places SET status = $2, moderation_reason = NULLIF($3, ''), moderated_by = NULLIF($4, 0), moderated_at = CURRENT_TIMESTAMP,
+		deleted_at = CASE WHEN $2 = 'archived' THEN COALESCE(deleted_at, CURRENT_TIMESTAMP) END WHERE id = $1`, placeID, status, reason, moderatorID)
+	if err != nil { return err }
+	if status == statusApproved {
+		if _, err := awardPoints(tx, creatorID, eventPlaceApproved, refPlace, placeID); err != nil { return err }
+		if err := restoreCommentPoints(tx, placeID); err != nil { return err }
+	} else if previous == statusApproved {
+		// Visitors keep their check-in points; only the approval is undone
+		if err := reverseEventPoints(tx, eventPlaceApproved, refPlace, placeID); err != nil { return err }
+		if err := suspendCommentPoints(tx, placeID); err != nil { return err }
+	}
+	var name map[string]string
+	json.Unmarshal(nameJSON, &name)
+	return notify(tx, creatorID, "place_moderated", refPlace, placeID, map[string]interface{}{"status": status, "previous_status": previous, "reason": reason, "name": name})
+}
+
+// suspendCommentPoints reverses the standing comment points of a place that
+// is being hidden and marks those comments for restoreCommentPoints.
+func suspendCommentPoints(tx *sql.Tx, placeID int) error {
+	rows, err := tx.Query(`SELECT c.id FROM comments c WHERE c.place_id = $1 AND NOT c.points_suspended
+		AND EXISTS (SELECT 1 FROM points_ledger l WHERE l.ref_type = $2 AND l.ref_id = c.id AND l.reverses IS NULL
+			AND NOT EXISTS (SELECT 1 FROM points_ledger r WHERE r.reverses = l.id))`, placeID, refComment)
+	if err != nil { return err }
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		if err := reversePoints(tx, refComment, id); err != nil { return err }
+	}
+	_, err = tx.Exec("UPDATE comments SET points_suspended = true WHERE id = ANY($1)", pq.Array(ids))
+	return err
+}
+
+// restoreCommentPoints gives back the comment points suspended when the
+// place was hidden.
+func restoreCommentPoints(tx *sql.Tx, placeID int) error {
+	rows, err := tx.Query("SELECT id FROM comments WHERE place_id = $1 AND points_suspended", placeID)
+	if err != nil { return err }
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		if err := restorePoints(tx, eventCommentAdded, refComment, id); err != nil { return err }
+	}
+	_, err = tx.Exec("UPDATE comments SET points_suspended = false WHERE id = ANY($1)", pq.Array(ids))
+	return err
+}
+
+// moderationAdmin handles POST approve, reject, request-changes and
+// archive. The body is {id} or {ids: [...]} for bulk decisions plus a
+// reason, which all but approve require. Each place is decided in its own
+// transaction and reported separately.
+func moderationAdmin(w http.ResponseWriter, r *http.Request, action string) {
+	if r.Method != "POST" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	var req struct {
+		ID     int    `json:"id"`
+		IDs    []int  `json:"ids"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "Invalid body", http.StatusBadRequest); return }
+	if req.ID > 0 { req.IDs = append(req.IDs, req.ID) }
+	if len(req.IDs) == 0 || len(req.IDs) > maxModerationBatch { http.Error(w, "id or ids (at most 200) required", http.StatusBadRequest); return }
+	status := moderationActions[action]
+	req.Reason = strings.TrimSpace(req.Reason)
+	if status != statusApproved && req.Reason == "" { http.Error(w, "A reason is required", http.StatusBadRequest); return }
+	if len(req.Reason) > 1000 { http.Error(w, "Reason too long", http.StatusBadRequest); return }
+	moderatorID, _ := currentUser(r)
+
+	results := []ModerationResult{}
+	found := false
+	for _, id := range req.IDs {
+		err := withTx(func(tx *sql.Tx) error { return moderatePlace(tx, id, moderatorID, status, req.Reason) })
+		switch {
+		case err == sql.ErrNoRows:
+			results = append(results, ModerationResult{ID: id, Error: "Place not found"})
+		case err != nil:
+			log.Printf("Moderate place %d (%s): %v", id, action, err)
+			results = append(results, ModerationResult{ID: id, Error: "Database error"})
+		default:
+			found = true
+			results = append(results, ModerationResult{ID: id, Status: status})
+		}
+	}
+	// A single decision keeps the plain status codes
+	if len(req.IDs) == 1 && !found {
+		code := http.StatusInternalServerError
+		if results[0].Error == "Place not found" { code = http.StatusNotFound }
+		http.Error(w, results[0].Error, code)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// notificationsHandler serves GET /api/notifications (&unread=1 for unread
+// only) with the unread count, and POST ?action=read {ids} to mark some as
+// read, or all of them when ids is empty.
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	userID, _ := currentUser(r)
+	if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+	switch {
+	case r.Method == "GET":
+		query := "SELECT id, kind, COALESCE(ref_type, ''), COALESCE(ref_id, 0), data, read_at IS NOT NULL, created_at FROM notifications WHERE user_id = $1"
+		if r.URL.Query().Get("unread") == "1" { query += " AND read_at IS NULL" }
+		rows, err := db.Query(query+" ORDER BY id DESC LIMIT 100", userID)
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		defer rows.Close()
+		list := []Notification{}
+		for rows.Next() {
+			var n Notification
+			var dataJSON []byte
+			rows.Scan(&n.ID, &n.Kind, &n.RefType, &n.RefID, &dataJSON, &n.Read, &n.CreatedAt)
+			json.Unmarshal(dataJSON, &n.Data)
+			list = append(list, n)
+		}
+		var unread int
+		db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL", userID).Scan(&unread)
+		json.NewEncoder(w).Encode(map[string]interface{}{"notifications": list, "unread": unread})
+	case r.Method == "POST" && r.URL.Query().Get("action") == "read":
+		var req struct { IDs []int `json:"ids"` }
+		json.NewDecoder(r.Body).Decode(&req)
+		var err error
+		if len(req.IDs) == 0 {
+			_, err = db.Exec("UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND read_at IS NULL", userID)
+		} else {
+			_, err = db.Exec("UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND read_at IS NULL AND id = ANY($2)", userID, pq.Array(req.IDs))
+		}
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// --- OSM PBF reader ---
+//
+// A minimal reader for OpenStreetMap .osm.pbf extracts, enough to build the
+// routing graph: node coordinates and way tags/refs. Relations, metadata
+// and non-zlib blob compression are not supported. Format reference:
+// https://wiki.openstreetmap.org/wiki/PBF_Format
+
+const maxPBFBlobSize = 32 << 20
+
+var errPBFCorrupt = errors.New("osm pbf: corrupt data")
+
+type osmWay struct {
+	ID   int64
+	Tags map[string]string
+	Refs []int64
+}
+
+// pbfHandler receives elements; a nil callback skips that element type.
+type pbfHandler struct {
+	Node func(id int64, lat, lng float64)
+	Way  func(w osmWay)
+}
+
+// pbfFields calls fn for each field of a protobuf message. Varint and
+// fixed values are in v, length-delimited ones in b.
+func pbfFields(buf []byte, fn func(num int, v uint64, b []byte) error) error {
+	for len(buf) > 0 {
+		key, n := binary.Uvarint(buf)
+		if n <= 0 { return errPBFCorrupt }
+		buf = buf[n:]
+		num := int(key >> 3)
+		var v uint64
+		var b []byte
+		switch key & 7 {
+		case 0:
+			if v, n = binary.Uvarint(buf); n <= 0 { return errPBFCorrupt }
+			buf = buf[n:]
+		case 1:
+			if len(buf) < 8 { return errPBFCorrupt }
+			v, buf = binary.LittleEndian.Uint64(buf), buf[8:]
+		case 2:
+			l, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < l { return errPBFCorrupt }
+			b, buf = buf[n:n+int(l)], buf[n+int(l):]
+		case 5:
+			if len(buf) < 4 { return errPBFCorrupt }
+			v, buf = uint64(binary.LittleEndian.Uint32(buf)), buf[4:]
+		default:
+			return errPBFCorrupt
+		}
+		if err := fn(num, v, b); err != nil { return err }
+	}
+	return nil
+}
+
+// pbfPacked decodes a packed repeated varint field.
+func pbfPacked(b []byte) ([]uint64, error) {
+	var out []uint64
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 { return nil, errPBFCorrupt }
+		out = append(out, v)
+		b = b[n:]
+	}
+	return out, nil
+}
+
+func zigzag(v uint64) int64 { return int64(v>>1) ^ -int64(v&1) }
+
+// readPBF streams the file at path through h.
+func readPBF(path string, h pbfHandler) error {
+	f, err := os.Open(path)
+	if err != nil { return err }
+	defer f.Close()
+	r := bufio.NewReaderSize(f, 1<<20)
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if size > 64<<10 { return errPBFCorrupt }
+		header := make([]byte, size)
+		if _, err := io.ReadFull(r, header); err != nil { return err }
+		var blobType string
+		var blobSize uint64
+		err := pbfFields(header, func(num int, v uint64, b []byte) error {
+			switch num {
+			case 1: blobType = string(b)
+			case 3: blobSize = v
+			}
+			return nil
+		})
+		if err != nil { return err }
+		if blobSize > maxPBFBlobSize { return errPBFCorrupt }
+		blob := make([]byte, blobSize)
+		if _, err := io.ReadFull(r, blob); err != nil { return err }
+		if blobType != "OSMData" { continue }
+		data, err := pbfBlobData(blob)
+		if err != nil { return err }
+		if err := pbfPrimitiveBlock(data, h); err != nil { return err }
+	}
+}
+
+func pbfBlobData(blob []byte) ([]byte, error) {
+	var raw, zdata []byte
+	var rawSize uint64
+	err := pbfFields(blob, func(num int, v uint64, b []byte) error {
+		switch num {
+		case 1: raw = b
+		case 2: rawSize = v
+		case 3: zdata = b
+		case 4, 5, 6, 7: return fmt.Errorf("osm pbf: unsupported blob compression (field %d)", num)
+		}
+		return nil
+	})
+	if err != nil || raw != nil { return raw, err }
+	if rawSize > maxPBFBlobSize { return nil, errPBFCorrupt }
+	zr, err := zlib.NewReader(bytes.NewReader(zdata))
+	if err != nil { return nil, err }
+	defer zr.Close()
+	out := make([]byte, 0, rawSize)
+	buf := bytes.NewBuffer(out)
+	_, err = io.Copy(buf, io.LimitReader(zr, maxPBFBlobSize))
+	return buf.Bytes(), err
+}
+
+func pbfPrimitiveBlock(data []byte, h pbfHandler) error {
+	var table []string
+	var groups [][]byte
+	granularity, latOffset, lonOffset := int64(100), int64(0), int64(0)
+	err := pbfFields(data, func(num int, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			return pbfFields(b, func(num int, _ uint64, s []byte) error {
+				if num == 1 { table = append(table, string(s)) }
+				return nil
+			})
+		case 2: groups = append(groups, b)
+		case 17: granularity = int64(v)
+		case 19: latOffset = int64(v)
+		case 20: lonOffset = int64(v)
+		}
+		return nil
+	})
+	if err != nil { return err }
+	coord := func(offset, v int64) float64 { return 1e-9 * float64(offset+granularity*v) }
+
+	for _, g := range groups {
+		err := pbfFields(g, func(num int, _ uint64, b []byte) error {
+			switch {
+			case num == 1 && h.Node != nil:
+				var id, lat, lon int64
+				pbfFields(b, func(num int, v uint64, _ []byte) error {
+					switch num {
+					case 1: id = zigzag(v)
+					case 8: lat = zigzag(v)
+					case 9: lon = zigzag(v)
+					}
+					return nil
+				})
+				h.Node(id, coord(latOffset, lat), coord(lonOffset, lon))
+			case num == 2 && h.Node != nil:
+				var ids, lats, lons []uint64
+				err := pbfFields(b, func(num int, _ uint64, p []byte) error {
+					var err error
+					switch num {
+					case 1: ids, err = pbfPacked(p)
+					case 8: lats, err = pbfPacked(p)
+					case 9: lons, err = pbfPacked(p)
+					}
+					return err
+				})
+				if err != nil { return err }
+				if len(lats) != len(ids) || len(lons) != len(ids) { return errPBFCorrupt }
+				var id, lat, lon int64
+				for i := range ids {
+					id, lat, lon = id+zigzag(ids[i]), lat+zigzag(lats[i]), lon+zigzag(lons[i])
+					h.Node(id, coord(latOffset, lat), coord(lonOffset, lon))
+				}
+			case num == 3 && h.Way != nil:
+				var w osmWay
+				var keys, vals, refs []uint64
+				err := pbfFields(b, func(num int, v uint64, p []byte) error {
+					var err error
+					switch num {
+					case 1: w.ID = int64(v)
+					case 2: keys, err = pbfPacked(p)
+					case 3: vals, err = pbfPacked(p)
+					case 8: refs, err = pbfPacked(p)
+					}
+					return err
+				})
+				if err != nil { return err }
+				if len(keys) != len(vals) { return errPBFCorrupt }
+				w.Tags = make(map[string]string, len(keys))
+				for i := range keys {
+					if keys[i] >= uint64(len(table)) || vals[i] >= uint64(len(table)) { return errPBFCorrupt }
+					w.Tags[table[keys[i]]] = table[vals[i]]
+				}
+				var ref int64
+				w.Refs = make([]int64, len(refs))
+				for i, d := range refs {
+					ref += zigzag(d)
+					w.Refs[i] = ref
+				}
+				h.Way(w)
+			}
+			return nil
+		})
+		if err != nil { return err }
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pbfMsg builds protobuf messages for test fixtures.
+type pbfMsg []byte
+
+func (m pbfMsg) varint(num int, v uint64) pbfMsg {
+	m = binary.AppendUvarint(m, uint64(num)<<3)
+	return binary.AppendUvarint(m, v)
+}
+
+func (m pbfMsg) bytes(num int, b []byte) pbfMsg {
+	m = binary.AppendUvarint(m, uint64(num)<<3|2)
+	m = binary.AppendUvarint(m, uint64(len(b)))
+	return append(m, b...)
+}
+
+// sint64s packs values as zigzag varints, delta coded when delta is set.
+func (m pbfMsg) sint64s(num int, vals []int64, delta bool) pbfMsg {
+	var packed []byte
+	prev := int64(0)
+	for _, v := range vals {
+		d := v
+		if delta { d, prev = v-prev, v }
+		packed = binary.AppendUvarint(packed, uint64(d<<1)^uint64(d>>63))
+	}
+	return m.bytes(num, packed)
+}
+
+func (m pbfMsg) uints(num int, vals []uint64) pbfMsg {
+	var packed []byte
+	for _, v := range vals { packed = binary.AppendUvarint(packed, v) }
+	return m.bytes(num, packed)
+}
+
+type fixtureNode struct {
+	id       int64
+	lat, lng float64
+}
+
+type fixtureWay struct {
+	id   int64
+	tags [][2]string
+	refs []int64
+}
+
+// writePBFFixture writes an .osm.pbf with an OSMHeader blob, one zlib
+// OSMData blob of dense nodes and one raw OSMData blob of ways.
+func writePBFFixture(t *testing.T, nodes []fixtureNode, ways []fixtureWay) string {
+	var ids, lats, lngs []int64
+	for _, n := range nodes {
+		ids = append(ids, n.id)
+		lats = append(lats, int64(math.Round(n.lat*1e7)))
+		lngs = append(lngs, int64(math.Round(n.lng*1e7)))
+	}
+	dense := pbfMsg{}.sint64s(1, ids, true).sint64s(8, lats, true).sint64s(9, lngs, true)
+	nodeBlock := pbfMsg{}.bytes(1, pbfMsg{}.bytes(1, nil)).bytes(2, pbfMsg{}.bytes(2, dense))
+
+	table := []string{""}
+	index := map[string]uint64{}
+	str := func(s string) uint64 {
+		if i, ok := index[s]; ok { return i }
+		index[s] = uint64(len(table))
+		table = append(table, s)
+		return index[s]
+	}
+	group := pbfMsg{}
+	for _, w := range ways {
+		var keys, vals []uint64
+		for _, kv := range w.tags { keys, vals = append(keys, str(kv[0])), append(vals, str(kv[1])) }
+		group = group.bytes(3, pbfMsg{}.varint(1, uint64(w.id)).uints(2, keys).uints(3, vals).sint64s(8, w.refs, true))
+	}
+	stringTable := pbfMsg{}
+	for _, s := range table { stringTable = stringTable.bytes(1, []byte(s)) }
+	wayBlock := pbfMsg{}.bytes(1, stringTable).bytes(2, group)
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(nodeBlock)
+	zw.Close()
+
+	var file bytes.Buffer
+	blob := func(kind string, body pbfMsg) {
+		header := pbfMsg{}.bytes(1, []byte(kind)).varint(3, uint64(len(body)))
+		binary.Write(&file, binary.BigEndian, uint32(len(header)))
+		file.Write(header)
+		file.Write(body)
+	}
+	blob("OSMHeader", pbfMsg{}.bytes(1, pbfMsg{}.bytes(4, []byte("DenseNodes"))))
+	blob("OSMData", pbfMsg{}.varint(2, uint64(len(nodeBlock))).bytes(3, z.Bytes()))
+	blob("OSMData", pbfMsg{}.bytes(1, wayBlock))
+
+	path := filepath.Join(t.TempDir(), "fixture.osm.pbf")
+	if err := os.WriteFile(path, file.Bytes(), 0o644); err != nil { t.Fatal(err) }
+	return path
+}
+
+func TestReadPBF(t *testing.T) {
+	// Ids and coordinates go up and down so deltas are both signs
+	nodes := []fixtureNode{{100, 41.0086, 28.9802}, {101, 41.0090, 28.9790}, {98, -33.8568, 151.2153}, {5000, 0, -0.1276}}
+	ways := []fixtureWay{{7, [][2]string{{"highway", "primary"}, {"name", "Divan Yolu"}}, []int64{101, 100, 98, 5000}}}
+	var gotNodes []fixtureNode
+	var gotWays []osmWay
+	err := readPBF(writePBFFixture(t, nodes, ways), pbfHandler{
+		Node: func(id int64, lat, lng float64) { gotNodes = append(gotNodes, fixtureNode{id, lat, lng}) },
+		Way:  func(w osmWay) { gotWays = append(gotWays, w) },
+	})
+	if err != nil { t.Fatal(err) }
+	if len(gotNodes) != len(nodes) { t.Fatalf("got %d nodes, want %d", len(gotNodes), len(nodes)) }
+	for i, n := range nodes {
+		g := gotNodes[i]
+		if g.id != n.id || math.Abs(g.lat-n.lat) > 1e-9 || math.Abs(g.lng-n.lng) > 1e-9 { t.Errorf("node %d = %+v, want %+v", i, g, n) }
+	}
+	if len(gotWays) != 1 { t.Fatalf("got %d ways, want 1", len(gotWays)) }
+	w := gotWays[0]
+	if w.ID != 7 || w.Tags["highway"] != "primary" || w.Tags["name"] != "Divan Yolu" || len(w.Tags) != 2 { t.Errorf("way = %+v", w) }
+	if len(w.Refs) != 4 || w.Refs[0] != 101 || w.Refs[1] != 100 || w.Refs[2] != 98 || w.Refs[3] != 5000 { t.Errorf("way refs = %v", w.Refs) }
+}
+
+func TestPBFDenseNodes(t *testing.T) {
+	tests := []struct {
+		name                   string
+		granularity, latOffset uint64
+		ids, lats, lons        []int64 // Raw deltas as stored
+		wantIDs                []int64
+		wantLat, wantLng       []float64
+	}{
+		{"default granularity", 0, 0, []int64{10, 1, -5}, []int64{410000000, 10, -20}, []int64{290000000, -10000, 0}, []int64{10, 11, 6}, []float64{41, 41.000001, 40.999999}, []float64{29, 28.999, 28.999}},
+		{"granularity and offset", 1000, 5e8, []int64{-3, 2}, []int64{1000, -1}, []int64{-2000, 2000}, []int64{-3, -1}, []float64{0.501, 0.500999}, []float64{-0.002, 0}},
+	}
+	for _, tt := range tests {
+		var dense pbfMsg
+		dense = dense.sint64s(1, tt.ids, false).sint64s(8, tt.lats, false).sint64s(9, tt.lons, false)
+		block := pbfMsg{}.bytes(2, pbfMsg{}.bytes(2, dense))
+		if tt.granularity > 0 { block = block.varint(17, tt.granularity) }
+		if tt.latOffset > 0 { block = block.varint(19, tt.latOffset) }
+		var i int
+		err := pbfPrimitiveBlock(block, pbfHandler{Node: func(id int64, lat, lng float64) {
+			if i < len(tt.wantIDs) && (id != tt.wantIDs[i] || math.Abs(lat-tt.wantLat[i]) > 1e-9 || math.Abs(lng-tt.wantLng[i]) > 1e-9) {
+				t.Errorf("%s: node %d = %d (%v, %v), want %d (%v, %v)", tt.name, i, id, lat, lng, tt.wantIDs[i], tt.wantLat[i], tt.wantLng[i])
+			}
+			i++
+		}})
+		if err != nil || i != len(tt.wantIDs) { t.Errorf("%s: %d nodes, err %v", tt.name, i, err) }
+	}
+
+	mismatched := pbfMsg{}.bytes(2, pbfMsg{}.bytes(2, pbfMsg{}.sint64s(1, []int64{1, 1}, false).sint64s(8, []int64{0}, false).sint64s(9, []int64{0, 0}, false)))
+	if err := pbfPrimitiveBlock(mismatched, pbfHandler{Node: func(int64, float64, float64) {}}); err != errPBFCorrupt { t.Errorf("mismatched dense arrays: %v, want errPBFCorrupt", err) }
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// --- Smart route planner ---
+//
+// POST /api/planner builds a day itinerary as an orienteering problem:
+// pick the subset of approved places that maximises total score without
+// exceeding the time budget, visited in a short order. It uses greedy
+// ratio insertion followed by 2-opt, repeated until no more places fit.
+// Must-visit places are inserted first, whatever their score, category or
+// distance; the request fails when they alone don't fit the budget.
+
+var plannerSpeedKmh = envFloat("PLANNER_SPEED_KMH", 30)          // Average door-to-door speed
+var plannerDetourFactor = envFloat("PLANNER_DETOUR_FACTOR", 1.3) // Road distance vs. straight line
+var plannerMaxCandidates = envInt("PLANNER_MAX_CANDIDATES", 150)
+
+var plannerBudgets = map[int]bool{120: true, 240: true, 480: true}
+
+const plannerMaxMustVisit = 10
+
+// plannerInterests maps the planner's interest IDs to category slugs;
+// subcategories are included. "mixed" (or no interests) means every
+// category.
+var plannerInterests = map[string][]string{
+	"history": {"historical"},
+	"nature":  {"nature"},
+	"fun":     {"shopping", "entertainment"},
+	"mixed":   nil,
+}
+
+// defaultVisitMinute is the dwell time for categories without one; the
+// rest come from categories.visit_minutes and requests can override them
+// with visit_minutes.
+const defaultVisitMinute = 45
+
+type PlannerRequest struct {
+	Lat           float64        `json:"lat"`
+	Lng           float64        `json:"lng"`
+	BudgetMinutes int            `json:"budget_minutes"`
+	Interests     []string       `json:"interests"`
+	VisitMinutes  map[string]int `json:"visit_minutes"` // Per category slug
+	ReturnToStart bool           `json:"return_to_start"`
+	Profile       string         `json:"profile"`    // car (default) or walk
+	MustVisit     []int          `json:"must_visit"` // Place IDs
+}
+
+type ItineraryStop struct {
+	Place         Place   `json:"place"`
+	ArriveMinute  int     `json:"arrive_minute"` // Minutes since departure
+	DepartMinute  int     `json:"depart_minute"`
+	TravelMinutes int     `json:"travel_minutes"` // From the previous stop
+	TravelKm      float64 `json:"travel_km"`
+	VisitMinutes  int     `json:"visit_minutes"`
+}
+
+type Itinerary struct {
+	BudgetMinutes int             `json:"budget_minutes"`
+	TotalMinutes  int             `json:"total_minutes"`
+	TravelMinutes int             `json:"travel_minutes"`
+	VisitMinutes  int             `json:"visit_minutes"`
+	DistanceKm    float64         `json:"distance_km"`
+	ReturnMinutes int             `json:"return_minutes,omitempty"` // Last leg back to the start
+	Stops         []ItineraryStop `json:"stops"`
+}
+
+type plannerCandidate struct {
+	place Place
+	visit float64
+	score float64
+	must  bool
+}
+
+// travelEstimate is the straight-line guess at road distance and travel
+// time, used when the routing graph can't answer.
+func travelEstimate(profile string, lat1, lng1, lat2, lng2 float64) (km, minutes float64) {
+	km = haversineKm(lat1, lng1, lat2, lng2) * plannerDetourFactor
+	speed := plannerSpeedKmh
+	if profile == profileWalk { speed = walkSpeedKmh }
+	return km, km / speed * 60
+}
+
+// plannerCandidates loads approved places in categories (all when empty)
+// reachable from the start within the budget, plus the must-visit places;
+// those come first, the rest nearest first. Score rewards good ratings,
+// activity and the user's own favorites.
+func plannerCandidates(req PlannerRequest, categories []string, userID int) ([]plannerCandidate, error) {
+	speed := plannerSpeedKmh
+	if req.Profile == profileWalk { speed = walkSpeedKmh }
+	reachKm := float64(req.BudgetMinutes) / 60 * speed / plannerDetourFactor
+	dLat := reachKm / 111
+	dLng := reachKm / (111 * math.Max(0.01, math.Cos(req.Lat*math.Pi/180)))
+	query := `
+		SELECT p.id, p.name, p.description, p.lat, p.lng, p.category, p.city, COALESCE(p.image_url, ''), p.status,
+			COALESCE((SELECT AVG(rating) FROM comments c WHERE c.place_id = p.id AND c.rating > 0), 0),
+			(SELECT COUNT(*) FROM comments c WHERE c.place_id = p.id) + (SELECT COUNT(*) FROM checkins k WHERE k.place_id = p.id),
+			EXISTS (SELECT 1 FROM favorites f WHERE f.place_id = p.id AND f.user_id = $5)
+		FROM places p
+		WHERE p.status = 'approved' AND (p.id = ANY($6) OR (p.lat BETWEEN $1 AND $2 AND p.lng BETWEEN $3 AND $4`
+	args := []interface{}{req.Lat - dLat, req.Lat + dLat, req.Lng - dLng, req.Lng + dLng, userID, pq.Array(req.MustVisit)}
+	if len(categories) > 0 {
+		query += " AND p.category = ANY($7)"
+		args = append(args, pq.Array(categories))
+	}
+	must := map[int]bool{}
+	for _, id := range req.MustVisit { must[id] = true }
+	rows, err := db.Query(query+"))", args...)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	var candidates []plannerCandidate
+	for rows.Next() {
+		var c plannerCandidate
+		var nameJSON, descJSON []byte
+		var rating float64
+		var activity int
+		var favorite bool
+		rows.Scan(&c.place.ID, &nameJSON, &descJSON, &c.place.Lat, &c.place.Lng, &c.place.Category, &c.place.City, &c.place.ImageURL, &c.place.Status, &rating, &activity, &favorite)
+		json.Unmarshal(nameJSON, &c.place.Name)
+		json.Unmarshal(descJSON, &c.place.Description)
+		c.place.Images = imageVariants(c.place.ImageURL)
+		c.place.IsFavorite = favorite
+		c.visit = float64(defaultVisitMinute)
+		if m, ok := categoryVisitMinutes(c.place.Category); ok { c.visit = float64(m) }
+		if m, ok := req.VisitMinutes[c.place.Category]; ok && m > 0 { c.visit = float64(m) }
+		c.score = 1 + rating/5 + 0.25*math.Log1p(float64(activity))
+		if favorite { c.score += 0.5 }
+		c.must = must[c.place.ID]
+		// Skip places that can't be reached and visited within the budget
+		if _, t := travelEstimate(req.Profile, req.Lat, req.Lng, c.place.Lat, c.place.Lng); !c.must && t+c.visit > float64(req.BudgetMinutes) { continue }
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].must != candidates[j].must { return candidates[i].must }
+		return haversineKm(req.Lat, req.Lng, candidates[i].place.Lat, candidates[i].place.Lng) < haversineKm(req.Lat, req.Lng, candidates[j].place.Lat, candidates[j].place.Lng)
+	})
+	if len(candidates) > plannerMaxCandidates { candidates = candidates[:plannerMaxCandidates] }
+	return candidates, nil
+}
+
+// planner holds the travel time matrix. Node 0 is the start, node i+1 is
+// candidates[i]. Times come from the road graph when it is loaded and the
+// start is on the network; places it can't reach within the budget get an
+// infinite time and are never picked.
+type planner struct {
+	candidates []plannerCandidate
+	minutes    [][]float64
+	km         [][]float64
+	budget     float64
+	closed     bool // Route returns to the start
+}
+
+func newPlanner(req PlannerRequest, candidates []plannerCandidate) *planner {
+	n := len(candidates) + 1
+	p := &planner{candidates: candidates, budget: float64(req.BudgetMinutes), closed: req.ReturnToStart}
+	lat := func(i int) float64 { if i == 0 { return req.Lat }; return candidates[i-1].place.Lat }
+	lng := func(i int) float64 { if i == 0 { return req.Lng }; return candidates[i-1].place.Lng }
+	p.minutes = make([][]float64, n)
+	p.km = make([][]float64, n)
+	g := roads.Load()
+	if g != nil {
+		if _, _, ok := g.snap(req.Profile, req.Lat, req.Lng); !ok { g = nil }
+	}
+	points := make([][2]float64, n)
+	for i := range points { points[i] = [2]float64{lat(i), lng(i)} }
+	for i := 0; i < n; i++ {
+		p.minutes[i] = make([]float64, n)
+		p.km[i] = make([]float64, n)
+		if g != nil {
+			for j, res := range g.travelMany(req.Profile, lat(i), lng(i), points, p.budget) {
+				p.km[i][j], p.minutes[i][j] = res.km, res.minutes
+				if !res.ok && i != j { p.minutes[i][j] = math.Inf(1) }
+			}
+			continue
+		}
+		for j := 0; j < n; j++ {
+			p.km[i][j], p.minutes[i][j] = travelEstimate(req.Profile, lat(i), lng(i), lat(j), lng(j))
+		}
+	}
+	return p
+}
+
+// travel is the driving time of route (node indices, start excluded).
+func (p *planner) travel(route []int) float64 {
+	total, prev := 0.0, 0
+	for _, n := range route {
+		total += p.minutes[prev][n]
+		prev = n
+	}
+	if p.closed { total += p.minutes[prev][0] }
+	return total
+}
+
+func (p *planner) cost(route []int) float64 {
+	total := p.travel(route)
+	for _, n := range route { total += p.candidates[n-1].visit }
+	return total
+}
+
+// cheapestInsert finds the position in route where node n adds the fewest
+// minutes, visit included.
+func (p *planner) cheapestInsert(route []int, n int) (bestPos int, bestAdded float64) {
+	bestAdded = math.Inf(1)
+	for pos := 0; pos <= len(route); pos++ {
+		prev := 0
+		if pos > 0 { prev = route[pos-1] }
+		added := p.minutes[prev][n] + p.candidates[n-1].visit
+		if pos < len(route) {
+			added += p.minutes[n][route[pos]] - p.minutes[prev][route[pos]]
+		} else if p.closed {
+			added += p.minutes[n][0] - p.minutes[prev][0]
+		}
+		if added < bestAdded { bestPos, bestAdded = pos, added }
+	}
+	return bestPos, bestAdded
+}
+
+func insertAt(route []int, pos, n int) []int {
+	route = append(route, 0)
+	copy(route[pos+1:], route[pos:])
+	route[pos] = n
+	return route
+}
+
+// insertBest adds the unvisited node with the best score per added minute
+// at its cheapest feasible position. It reports whether anything fit.
+func (p *planner) insertBest(route []int, visited []bool) ([]int, bool) {
+	current := p.cost(route)
+	bestNode, bestPos, bestRatio := -1, 0, 0.0
+	for n := 1; n <= len(p.candidates); n++ {
+		if visited[n] { continue }
+		c := p.candidates[n-1]
+		pos, added := p.cheapestInsert(route, n)
+		if current+added > p.budget { continue }
+		if ratio := c.score * c.score / math.Max(added, 1); ratio > bestRatio { bestNode, bestPos, bestRatio = n, pos, ratio }
+	}
+	if bestNode < 0 { return route, false }
+	visited[bestNode] = true
+	return insertAt(route, bestPos, bestNode), true
+}
+
+// twoOpt reverses segments while that shortens the route.
+func (p *planner) twoOpt(route []int) []int {
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < len(route)-1; i++ {
+			for j := i + 1; j < len(route); j++ {
+				candidate := append([]int(nil), route...)
+				for a, b := i, j; a < b; a, b = a+1, b-1 { candidate[a], candidate[b] = candidate[b], candidate[a] }
+				if p.travel(candidate) < p.travel(route)-1e-9 {
+					route = candidate
+					improved = true
+				}
+			}
+		}
+	}
+	return route
+}
+
+// solve places the must-visit nodes, then alternates insertion and 2-opt:
+// shortening the route frees time that the next insertion round can spend
+// on more places. It fails when the must-visit nodes exceed the budget.
+func (p *planner) solve() ([]int, bool) {
+	visited := make([]bool, len(p.candidates)+1)
+	var route []int
+	for n := 1; n <= len(p.candidates); n++ {
+		if !p.candidates[n-1].must { continue }
+		pos, _ := p.cheapestInsert(route, n)
+		route = insertAt(route, pos, n)
+		visited[n] = true
+	}
+	route = p.twoOpt(route)
+	if p.cost(route) > p.budget { return nil, false }
+	for {
+		inserted := false
+		for ok := true; ok; {
+			if route, ok = p.insertBest(route, visited); ok { inserted = true }
+		}
+		if !inserted { return route, true }
+		route = p.twoOpt(route)
+	}
+}
+
+func (p *planner) itinerary(route []int) Itinerary {
+	it := Itinerary{BudgetMinutes: int(p.budget), Stops: []ItineraryStop{}}
+	clock, prev := 0.0, 0
+	for _, n := range route {
+		c := p.candidates[n-1]
+		clock += p.minutes[prev][n]
+		stop := ItineraryStop{
+			Place: c.place, ArriveMinute: int(math.Round(clock)),
+			TravelMinutes: int(math.Round(p.minutes[prev][n])), TravelKm: math.Round(p.km[prev][n]*10) / 10,
+			VisitMinutes: int(c.visit),
+		}
+		clock += c.visit
+		stop.DepartMinute = int(math.Round(clock))
+		it.Stops = append(it.Stops, stop)
+		it.TravelMinutes += stop.TravelMinutes
+		it.VisitMinutes += stop.VisitMinutes
+		it.DistanceKm += p.km[prev][n]
+		prev = n
+	}
+	if p.closed && len(route) > 0 {
+		it.ReturnMinutes = int(math.Round(p.minutes[prev][0]))
+		it.TravelMinutes += it.ReturnMinutes
+		it.DistanceKm += p.km[prev][0]
+	}
+	it.DistanceKm = math.Round(it.DistanceKm*10) / 10
+	it.TotalMinutes = it.TravelMinutes + it.VisitMinutes
+	return it
+}
+
+// plannerHandler serves POST /api/planner. Authentication is optional and
+// only used to favour the caller's favorites.
+func plannerHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "POST" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	var req PlannerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "Invalid request", http.StatusBadRequest); return }
+	if !validCoords(req.Lat, req.Lng) { http.Error(w, "Invalid coordinates", http.StatusBadRequest); return }
+	if !plannerBudgets[req.BudgetMinutes] { http.Error(w, "budget_minutes must be 120, 240 or 480", http.StatusBadRequest); return }
+	if req.Profile == "" { req.Profile = profileCar }
+	if !validProfile(req.Profile) { http.Error(w, "Invalid profile", http.StatusBadRequest); return }
+	if len(req.MustVisit) > plannerMaxMustVisit { http.Error(w, fmt.Sprintf("At most %d must-visit places", plannerMaxMustVisit), http.StatusBadRequest); return }
+	var categories []string
+	for _, interest := range req.Interests {
+		cats, ok := plannerInterests[interest]
+		if !ok { http.Error(w, "Unknown interest: "+interest, http.StatusBadRequest); return }
+		if cats == nil { categories = nil; break }
+		categories = append(categories, categoryWithDescendants(cats)...)
+	}
+
+	userID, _ := currentUser(r)
+	candidates, err := plannerCandidates(req, categories, userID)
+	if err != nil {
+		log.Printf("Planner: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	must := map[int]bool{}
+	for _, id := range req.MustVisit { must[id] = true }
+	for _, c := range candidates {
+		if c.must { delete(must, c.place.ID) }
+	}
+	if len(must) > 0 { http.Error(w, "Unknown must-visit place", http.StatusBadRequest); return }
+	p := newPlanner(req, candidates)
+	route, ok := p.solve()
+	if !ok { http.Error(w, "Must-visit places don't fit in the time budget", http.StatusUnprocessableEntity); return }
+	json.NewEncoder(w).Encode(p.itinerary(route))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// linePlanner puts the start and four places on a line at minutes 0, 10,
+// 20, 30 and 60; every place takes 30 minutes and scores the same.
+func linePlanner(budget float64, closed bool, must ...int) *planner {
+	pos := []float64{0, 10, 20, 30, 60}
+	p := &planner{budget: budget, closed: closed}
+	for i := range pos {
+		p.minutes = append(p.minutes, make([]float64, len(pos)))
+		p.km = append(p.km, make([]float64, len(pos)))
+		for j := range pos {
+			d := pos[i] - pos[j]
+			if d < 0 { d = -d }
+			p.minutes[i][j], p.km[i][j] = d, d/2
+		}
+		if i > 0 { p.candidates = append(p.candidates, plannerCandidate{place: Place{ID: 100 + i}, visit: 30, score: 1}) }
+	}
+	for _, n := range must { p.candidates[n-1].must = true }
+	return p
+}
+
+func TestPlannerSolve(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       *planner
+		want    string
+		total   int
+		returns int
+	}{
+		{"fills the budget exactly", linePlanner(120, false), "[1 2 3]", 120, 0},
+		{"small budget", linePlanner(60, false), "[1]", 40, 0},
+		{"return to start costs the last leg", linePlanner(120, true), "[2 1]", 100, 10},
+		{"must-visit far place", linePlanner(120, false, 4), "[1 4]", 120, 0},
+		{"must-visit with return", linePlanner(180, true, 4), "[1 4]", 180, 60},
+	}
+	for _, tt := range tests {
+		route, ok := tt.p.solve()
+		if !ok { t.Errorf("%s: no route", tt.name); continue }
+		if got := fmt.Sprint(route); got != tt.want { t.Errorf("%s: route %s, want %s", tt.name, got, tt.want) }
+		if cost := tt.p.cost(route); cost > tt.p.budget { t.Errorf("%s: cost %v over budget %v", tt.name, cost, tt.p.budget) }
+		it := tt.p.itinerary(route)
+		if it.TotalMinutes != tt.total || it.ReturnMinutes != tt.returns { t.Errorf("%s: itinerary total %d return %d, want %d and %d", tt.name, it.TotalMinutes, it.ReturnMinutes, tt.total, tt.returns) }
+	}
+
+	if route, ok := linePlanner(60, false, 4).solve(); ok { t.Errorf("must-visit over budget: got route %v", route) }
+}
+
+func TestPlannerItinerary(t *testing.T) {
+	p := linePlanner(240, true)
+	it := p.itinerary([]int{1, 3})
+	if len(it.Stops) != 2 { t.Fatalf("got %d stops", len(it.Stops)) }
+	first, second := it.Stops[0], it.Stops[1]
+	if first.Place.ID != 101 || first.ArriveMinute != 10 || first.DepartMinute != 40 || first.TravelKm != 5 { t.Errorf("first stop = %+v", first) }
+	if second.Place.ID != 103 || second.ArriveMinute != 60 || second.DepartMinute != 90 || second.TravelMinutes != 20 { t.Errorf("second stop = %+v", second) }
+	if it.ReturnMinutes != 30 || it.TravelMinutes != 60 || it.VisitMinutes != 60 || it.TotalMinutes != 120 || it.DistanceKm != 30 { t.Errorf("itinerary = %+v", it) }
+}
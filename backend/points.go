@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// --- Points ledger ---
+//
+// Points are never bumped in place. Every award is an append-only row in
+// points_ledger, a reversal is a second row with the negated amount that
+// points back at the original, and users.points is recomputed from the
+// ledger inside the same transaction.
+
+// Ledger event types. Their point values live in point_rules.
+const (
+	eventPlaceApproved = "place_approved"
+	eventCommentAdded  = "comment_added"
+	eventLegacyBalance = "legacy_balance" // Opening balance from before the ledger
+)
+
+// Ledger reference types, i.e. what ref_id points at.
+const (
+	refPlace   = "place"
+	refComment = "comment"
+)
+
+// defaultPointRules seeds point_rules; admins can change values later.
+var defaultPointRules = []PointRule{
+	{EventType: eventPlaceApproved, Points: 50, Description: "Place approved by a moderator"}, // Limited at submission instead, see placeDailyLimit
+	{EventType: eventCommentAdded, Points: 10, Description: "Comment posted", DailyCap: 10},
+}
+
+type PointRule struct {
+	EventType   string `json:"event_type"`
+	Points      int    `json:"points"`
+	Description string `json:"description"`
+	DailyCap    int    `json:"daily_cap"` // Awards per user per day, 0 for unlimited
+}
+
+type LedgerEntry struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	EventType string    `json:"event_type"`
+	Points    int       `json:"points"`
+	RefType   string    `json:"ref_type,omitempty"`
+	RefID     int       `json:"ref_id,omitempty"`
+	Reverses  int       `json:"reverses,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func initPointsTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS point_rules (
+		event_type TEXT PRIMARY KEY,
+		points INT NOT NULL,
+		description TEXT DEFAULT ''
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS points_ledger (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		event_type TEXT NOT NULL,
+		points INT NOT NULL,
+		ref_type TEXT,
+		ref_id INT,
+		reverses INT UNIQUE REFERENCES points_ledger(id),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec("ALTER TABLE point_rules ADD COLUMN IF NOT EXISTS daily_cap INT")
+	db.Exec("ALTER TABLE points_ledger ADD COLUMN IF NOT EXISTS clawback BOOLEAN NOT NULL DEFAULT false") // Set on reversals that block re-awarding
+	db.Exec("CREATE INDEX IF NOT EXISTS points_ledger_ref ON points_ledger (ref_type, ref_id)")
+	db.Exec("CREATE INDEX IF NOT EXISTS points_ledger_user ON points_ledger (user_id, event_type, created_at)")
+	for _, rule := range defaultPointRules { seedPointRule(rule) }
+	// Approvals used to be capped like comments, which dropped points when a
+	// moderator cleared a backlog; lift the old default
+	db.Exec("UPDATE point_rules SET daily_cap = 0 WHERE event_type = $1 AND daily_cap = 10", eventPlaceApproved)
+	// Carry balances from the old fire-and-forget counters into the ledger
+	db.Exec(`INSERT INTO points_ledger (user_id, event_type, points)
+		SELECT id, $1, points FROM users u
+		WHERE points <> 0 AND NOT EXISTS (SELECT 1 FROM points_ledger l WHERE l.user_id = u.id)`, eventLegacyBalance)
+}
+
+// seedPointRule adds a default rule without overwriting admin changes. The
+// cap is also filled in on rules created before caps existed.
+func seedPointRule(rule PointRule) {
+	db.Exec("INSERT INTO point_rules (event_type, points, description, daily_cap) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING", rule.EventType, rule.Points, rule.Description, rule.DailyCap)
+	db.Exec("UPDATE point_rules SET daily_cap = $2 WHERE event_type = $1 AND daily_cap IS NULL", rule.EventType, rule.DailyCap)
+}
+
+// withTx runs fn in a transaction, committing only if it returns nil.
+func withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil { return err }
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// syncUserPoints recomputes the cached users.points from the ledger.
+func syncUserPoints(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("UPDATE users SET points = (SELECT COALESCE(SUM(points), 0) FROM points_ledger WHERE user_id = $1) WHERE id = $1", userID)
+	return err
+}
+
+// awardPoints appends an award for eventType according to point_rules.
+// Awarding the same event for the same object while an earlier award is
+// still standing is a no-op, as is an event with no rule or a zero rule.
+// Once reversed, the award can be earned again (e.g. a place re-approved),
+// unless the reversal was a clawback.
+// Awards beyond the rule's daily cap are dropped, and each award runs the
+// velocity check that flags suspected farming for admins.
+// It returns the ledger ID, or 0 when nothing was written.
+func awardPoints(tx *sql.Tx, userID int, eventType, refType string, refID int) (int, error) {
+	if userID <= 0 { return 0, nil }
+	var points, dailyCap int
+	if err := tx.QueryRow("SELECT points, COALESCE(daily_cap, 0) FROM point_rules WHERE event_type = $1", eventType).Scan(&points, &dailyCap); err != nil {
+		if err == sql.ErrNoRows { return 0, nil }
+		return 0, err
+	}
+	if points == 0 { return 0, nil }
+	// Serialise concurrent awards for the same object until commit
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", fmt.Sprintf("points:%d:%s:%s:%d", userID, eventType, refType, refID)); err != nil { return 0, err }
+	var blocked bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM points_ledger l
+		WHERE l.user_id = $1 AND l.event_type = $2 AND l.ref_type = $3 AND l.ref_id = $4 AND l.reverses IS NULL
+		AND NOT EXISTS (SELECT 1 FROM points_ledger r WHERE r.reverses = l.id AND NOT r.clawback))`, userID, eventType, refType, refID).Scan(&blocked)
+	if err != nil || blocked { return 0, err }
+	if capped, err := dailyCapReached(tx, userID, eventType, dailyCap); err != nil || capped { return 0, err }
+	var id int
+	err = tx.QueryRow("INSERT INTO points_ledger (user_id, event_type, points, ref_type, ref_id) VALUES ($1, $2, $3, $4, $5) RETURNING id", userID, eventType, points, refType, refID).Scan(&id)
+	if err != nil { return 0, err }
+	if err := syncUserPoints(tx, userID); err != nil { return 0, err }
+	if err := checkVelocity(tx, userID); err != nil { return 0, err }
+	return id, evaluateBadges(tx, userID)
+}
+
+// reversePoints cancels every not-yet-reversed award tied to the object.
+func reversePoints(tx *sql.Tx, refType string, refID int) error {
+	return reverseEventPoints(tx, "", refType, refID)
+}
+
+// reverseEventPoints is reversePoints for one event type only, e.g. the
+// approval of a place but not the check-ins of its visitors.
+func reverseEventPoints(tx *sql.Tx, eventType, refType string, refID int) error {
+	rows, err := tx.Query(`SELECT l.id, l.user_id, l.event_type, l.points FROM points_ledger l
+		WHERE l.ref_type = $1 AND l.ref_id = $2 AND ($3 = '' OR l.event_type = $3) AND l.reverses IS NULL
+		AND NOT EXISTS (SELECT 1 FROM points_ledger r WHERE r.reverses = l.id)`, refType, refID, eventType)
+	if err != nil { return err }
+	var entries []LedgerEntry
+	for rows.Next() {
+		var e LedgerEntry
+		rows.Scan(&e.ID, &e.UserID, &e.EventType, &e.Points)
+		entries = append(entries, e)
+	}
+	rows.Close()
+	for _, e := range entries {
+		if err := reverseEntry(tx, e, false); err != nil { return err }
+	}
+	return nil
+}
+
+// restorePoints reinstates the latest reversed eventType award tied to the
+// object, e.g. when a moderator undoes the decision that reversed it.
+// Clawbacks stay in force, and caps don't apply since the points were
+// earned once already.
+func restorePoints(tx *sql.Tx, eventType, refType string, refID int) error {
+	var userID, points int
+	err := tx.QueryRow(`SELECT l.user_id, l.points FROM points_ledger l
+		WHERE l.event_type = $1 AND l.ref_type = $2 AND l.ref_id = $3 AND l.reverses IS NULL
+		AND EXISTS (SELECT 1 FROM points_ledger r WHERE r.reverses = l.id AND NOT r.clawback)
+		AND NOT EXISTS (SELECT 1 FROM points_ledger s WHERE s.event_type = l.event_type AND s.ref_type = l.ref_type AND s.ref_id = l.ref_id AND s.reverses IS NULL
+			AND NOT EXISTS (SELECT 1 FROM points_ledger r WHERE r.reverses = s.id))
+		ORDER BY l.id DESC LIMIT 1`, eventType, refType, refID).Scan(&userID, &points)
+	if err == sql.ErrNoRows { return nil }
+	if err != nil { return err }
+	if _, err := tx.Exec("INSERT INTO points_ledger (user_id, event_type, points, ref_type, ref_id) VALUES ($1, $2, $3, $4, $5)", userID, eventType, points, refType, refID); err != nil { return err }
+	if err := syncUserPoints(tx, userID); err != nil { return err }
+	return evaluateBadges(tx, userID)
+}
+
+// reverseEntry appends the negation of a single ledger entry. A clawback
+// also keeps the award from being earned again.
+func reverseEntry(tx *sql.Tx, e LedgerEntry, clawback bool) error {
+	if _, err := tx.Exec("INSERT INTO points_ledger (user_id, event_type, points, ref_type, ref_id, reverses, clawback) SELECT user_id, event_type, -points, ref_type, ref_id, id, $2 FROM points_ledger WHERE id = $1", e.ID, clawback); err != nil { return err }
+	return syncUserPoints(tx, e.UserID)
+}
+
+// userLedger lists a user's ledger, newest first.
+func userLedger(userID int) ([]LedgerEntry, error) {
+	rows, err := db.Query("SELECT id, user_id, event_type, points, COALESCE(ref_type, ''), COALESCE(ref_id, 0), COALESCE(reverses, 0), created_at FROM points_ledger WHERE user_id = $1 ORDER BY id DESC LIMIT 200", userID)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		var e LedgerEntry
+		rows.Scan(&e.ID, &e.UserID, &e.EventType, &e.Points, &e.RefType, &e.RefID, &e.Reverses, &e.CreatedAt)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// pointRulesAdmin backs /api/admin?action=point-rules. GET lists the rules,
+// PUT upserts one. Changes only affect future awards.
+func pointRulesAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "PUT" {
+		var rule PointRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil || rule.EventType == "" { http.Error(w, "Invalid request", http.StatusBadRequest); return }
+		_, err := db.Exec("INSERT INTO point_rules (event_type, points, description, daily_cap) VALUES ($1, $2, $3, $4) ON CONFLICT (event_type) DO UPDATE SET points = EXCLUDED.points, description = EXCLUDED.description, daily_cap = EXCLUDED.daily_cap", rule.EventType, rule.Points, rule.Description, rule.DailyCap)
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		json.NewEncoder(w).Encode(rule)
+		return
+	}
+	rows, err := db.Query("SELECT event_type, points, COALESCE(description, ''), COALESCE(daily_cap, 0) FROM point_rules ORDER BY event_type")
+	if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+	defer rows.Close()
+	rules := []PointRule{}
+	for rows.Next() {
+		var rule PointRule
+		rows.Scan(&rule.EventType, &rule.Points, &rule.Description, &rule.DailyCap)
+		rules = append(rules, rule)
+	}
+	json.NewEncoder(w).Encode(rules)
+}
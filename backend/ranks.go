@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// --- Ranks ---
+//
+// Ranks are derived from users.points (itself derived from the points
+// ledger). Each rank can unlock privileges; a user holds the privileges of
+// their rank and every rank below it.
+
+const privilegeAutoApprove = "auto_approve_places" // New places skip moderation
+const privilegeEditPlaces = "edit_places"           // Edits to others' places skip the suggestion queue
+
+type Rank struct {
+	ID         string            `json:"id"`
+	Icon       string            `json:"icon"`
+	Color      string            `json:"color"`
+	MinPoints  int               `json:"min_points"`
+	Title      map[string]string `json:"title"`
+	Privileges []string          `json:"privileges"`
+}
+
+// RankInfo is the rank block embedded in User responses.
+type RankInfo struct {
+	Current      Rank     `json:"current"`
+	Next         *Rank    `json:"next"`
+	Progress     int      `json:"progress"` // Percent towards Next, 100 at max rank
+	PointsToNext int      `json:"points_to_next"`
+	Privileges   []string `json:"privileges"` // Everything unlocked so far
+}
+
+// ranks must stay sorted by MinPoints.
+var ranks = []Rank{
+	{
+		ID: "explorer", Icon: "🌱", Color: "text-emerald-500", MinPoints: 0,
+		Title: map[string]string{"tr": "Yeni Kaşif", "en": "New Explorer", "de": "Neuer Entdecker", "fr": "Nouvel explorateur", "ru": "Новый исследователь", "ar": "مستكشف جديد"},
+	},
+	{
+		ID: "local_guide", Icon: "🧭", Color: "text-blue-500", MinPoints: 100,
+		Title: map[string]string{"tr": "Yerel Rehber", "en": "Local Guide", "de": "Lokaler Guide", "fr": "Guide local", "ru": "Местный гид", "ar": "مرشد محلي"},
+	},
+	{
+		ID: "route_master", Icon: "🗺️", Color: "text-purple-500", MinPoints: 500,
+		Title:      map[string]string{"tr": "Rota Ustası", "en": "Route Master", "de": "Routenmeister", "fr": "Maître des itinéraires", "ru": "Мастер маршрутов", "ar": "سيد الطرق"},
+		Privileges: []string{privilegeAutoApprove},
+	},
+	{
+		ID: "legend", Icon: "👑", Color: "text-yellow-500", MinPoints: 1000,
+		Title:      map[string]string{"tr": "Efsane", "en": "Legend", "de": "Legende", "fr": "Légende", "ru": "Легенда", "ar": "أسطورة"},
+		Privileges: []string{privilegeEditPlaces},
+	},
+}
+
+// rankIndex returns the index of the highest rank reached with points.
+func rankIndex(points int) int {
+	idx := 0
+	for i, r := range ranks {
+		if points >= r.MinPoints { idx = i }
+	}
+	return idx
+}
+
+func rankInfo(points int) *RankInfo {
+	idx := rankIndex(points)
+	info := &RankInfo{Current: ranks[idx], Progress: 100, Privileges: []string{}}
+	for _, r := range ranks[:idx+1] { info.Privileges = append(info.Privileges, r.Privileges...) }
+	if idx+1 < len(ranks) {
+		next := ranks[idx+1]
+		info.Next = &next
+		info.PointsToNext = next.MinPoints - points
+		info.Progress = (points - info.Current.MinPoints) * 100 / (next.MinPoints - info.Current.MinPoints)
+	}
+	return info
+}
+
+// userHasPrivilege reports whether the user's rank unlocks privilege.
+// Admins hold every privilege.
+func userHasPrivilege(userID int, privilege string) bool {
+	var points int
+	var role string
+	if err := db.QueryRow("SELECT points, COALESCE(role, '') FROM users WHERE id = $1", userID).Scan(&points, &role); err != nil { return false }
+	if role == "admin" { return true }
+	for _, p := range rankInfo(points).Privileges {
+		if p == privilege { return true }
+	}
+	return false
+}
+
+// ranksHandler serves GET /api/ranks, the rank ladder for clients.
+func ranksHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "GET" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	json.NewEncoder(w).Encode(ranks)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// --- Reverse geocoding ---
+//
+// Places get their city (province) and district from lat/lng instead of
+// trusting free text. The bundled province list gives canonical names and
+// capitals. Boundary polygons are not shipped: `fetch-boundaries` downloads
+// the simplified geoBoundaries TUR ADM1/ADM2 files into geodata/, where the
+// build embeds them, or GEO_PROVINCES_GEOJSON and GEO_DISTRICTS_GEOJSON
+// point at files on disk. Without them no district is derived. The nearest
+// provincial capital is a last resort: it never overrides a polygon or a
+// typed province, and only counts for points close to a capital, since
+// further out it often names the neighbouring province.
+
+//go:embed geodata/tr-provinces.json
+var provincesJSON []byte
+
+//go:embed geodata
+var geodataFS embed.FS
+
+const (
+	bundledProvincesFile = "geodata/tr-adm1.geojson"
+	bundledDistrictsFile = "geodata/tr-adm2.geojson"
+)
+
+var geoProvincesPath = getEnv("GEO_PROVINCES_GEOJSON", "")
+var geoDistrictsPath = getEnv("GEO_DISTRICTS_GEOJSON", "")
+var nearestCapitalKm = envFloat("GEO_CAPITAL_RADIUS_KM", 15) // Roughly a capital's own urban area
+
+type province struct {
+	Code    int      `json:"code"`
+	Name    string   `json:"name"`
+	Lat     float64  `json:"lat"`
+	Lng     float64  `json:"lng"`
+	Aliases []string `json:"aliases"`
+}
+
+// boundary is one administrative area; rings are [lng, lat] and the first
+// ring of each polygon is the outer one.
+type boundary struct {
+	name, province                 string
+	polygons                       [][][][2]float64
+	minLat, minLng, maxLat, maxLng float64
+}
+
+type gazetteer struct {
+	provinces []province
+	names     map[string]string // Folded name or alias -> canonical province
+	provAreas []boundary
+	districts []boundary
+}
+
+var geo atomic.Pointer[gazetteer]
+
+// Location is the result of a reverse lookup. Exact is false when it came
+// from the nearest capital rather than a boundary polygon.
+type Location struct {
+	City     string `json:"city"`
+	District string `json:"district,omitempty"`
+	Exact    bool   `json:"exact"`
+}
+
+var turkishFold = strings.NewReplacer("İ", "i", "I", "i", "ı", "i", "Ş", "s", "ş", "s", "Ğ", "g", "ğ", "g", "Ü", "u", "ü", "u", "Ö", "o", "ö", "o", "Ç", "c", "ç", "c", "Â", "a", "â", "a", "Î", "i", "î", "i", "Û", "u", "û", "u")
+
+// foldName makes "ISTANBUL", "istanbul" and "İstanbul" compare equal.
+func foldName(s string) string {
+	return strings.ToLower(turkishFold.Replace(strings.Join(strings.Fields(s), " ")))
+}
+
+// loadGazetteer parses the bundled provinces and any configured boundary
+// files, then publishes the result.
+func loadGazetteer() error {
+	g := &gazetteer{names: map[string]string{}}
+	if err := json.Unmarshal(provincesJSON, &g.provinces); err != nil { return fmt.Errorf("provinces: %w", err) }
+	for _, p := range g.provinces {
+		g.names[foldName(p.Name)] = p.Name
+		for _, a := range p.Aliases { g.names[foldName(a)] = p.Name }
+	}
+	var err error
+	if g.provAreas, err = loadBoundaries(geoProvincesPath, bundledProvincesFile, g, false); err != nil { return err }
+	if g.districts, err = loadBoundaries(geoDistrictsPath, bundledDistrictsFile, g, true); err != nil { return err }
+	geo.Store(g)
+	log.Printf("Geocoder: %d provinces, %d province and %d district boundaries", len(g.provinces), len(g.provAreas), len(g.districts))
+	if len(g.provAreas) == 0 && len(g.districts) == 0 { log.Printf("Geocoder: no boundary polygons, run fetch-boundaries to derive cities and districts from coordinates") }
+	return nil
+}
+
+// loadBoundaries reads a GeoJSON FeatureCollection of (Multi)Polygons from
+// path, or from the bundled file when path is empty; a missing bundled file
+// just means no polygons. Names come from the usual geoBoundaries/GADM
+// properties; district features may also name their province.
+func loadBoundaries(path, bundled string, g *gazetteer, districts bool) ([]boundary, error) {
+	var data []byte
+	var err error
+	if path != "" {
+		data, err = os.ReadFile(path)
+	} else {
+		path = bundled
+		data, err = geodataFS.ReadFile(bundled)
+		if errors.Is(err, fs.ErrNotExist) { return nil, nil }
+	}
+	if err != nil { return nil, err }
+	var fc struct {
+		Features []struct {
+			Properties map[string]interface{} `json:"properties"`
+			Geometry   struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(data, &fc); err != nil { return nil, fmt.Errorf("%s: %w", path, err) }
+	prop := func(props map[string]interface{}, keys ...string) string {
+		for _, k := range keys {
+			if s, ok := props[k].(string); ok && s != "" { return s }
+		}
+		return ""
+	}
+	var out []boundary
+	for i, f := range fc.Features {
+		b := boundary{minLat: math.Inf(1), minLng: math.Inf(1), maxLat: math.Inf(-1), maxLng: math.Inf(-1)}
+		if districts {
+			b.name = prop(f.Properties, "shapeName", "NAME_2", "name", "ilce")
+			b.province = g.canonicalProvince(prop(f.Properties, "province", "NAME_1", "il"))
+		} else {
+			b.name = prop(f.Properties, "shapeName", "NAME_1", "name", "il")
+			if c := g.canonicalProvince(b.name); c != "" { b.name = c }
+		}
+		var raw [][][][]float64
+		switch f.Geometry.Type {
+		case "Polygon":
+			var poly [][][]float64
+			err = json.Unmarshal(f.Geometry.Coordinates, &poly)
+			raw = [][][][]float64{poly}
+		case "MultiPolygon":
+			err = json.Unmarshal(f.Geometry.Coordinates, &raw)
+		default:
+			continue
+		}
+		if err != nil { return nil, fmt.Errorf("%s feature %d: %w", path, i, err) }
+		for _, poly := range raw {
+			var rings [][][2]float64
+			for _, ring := range poly {
+				pts := make([][2]float64, 0, len(ring))
+				for _, c := range ring {
+					if len(c) < 2 { continue }
+					pts = append(pts, [2]float64{c[0], c[1]})
+					b.minLng, b.maxLng = math.Min(b.minLng, c[0]), math.Max(b.maxLng, c[0])
+					b.minLat, b.maxLat = math.Min(b.minLat, c[1]), math.Max(b.maxLat, c[1])
+				}
+				rings = append(rings, pts)
+			}
+			b.polygons = append(b.polygons, rings)
+		}
+		if b.name != "" && len(b.polygons) > 0 { out = append(out, b) }
+	}
+	return out, nil
+}
+
+// ringContains is the even-odd ray test.
+func ringContains(ring [][2]float64, lat, lng float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > lat) != (b[1] > lat) && lng < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] { in = !in }
+	}
+	return in
+}
+
+func (b *boundary) contains(lat, lng float64) bool {
+	if lat < b.minLat || lat > b.maxLat || lng < b.minLng || lng > b.maxLng { return false }
+	for _, poly := range b.polygons {
+		if len(poly) == 0 || !ringContains(poly[0], lat, lng) { continue }
+		inHole := false
+		for _, hole := range poly[1:] {
+			if ringContains(hole, lat, lng) { inHole = true; break }
+		}
+		if !inHole { return true }
+	}
+	return false
+}
+
+// canonicalProvince returns the official spelling of a province name or
+// alias, or "" if it isn't one.
+func (g *gazetteer) canonicalProvince(name string) string {
+	if g == nil { return "" }
+	return g.names[foldName(name)]
+}
+
+func (g *gazetteer) lookup(lat, lng float64) Location {
+	var loc Location
+	for i := range g.provAreas {
+		if g.provAreas[i].contains(lat, lng) { loc = Location{City: g.provAreas[i].name, Exact: true}; break }
+	}
+	for i := range g.districts {
+		d := &g.districts[i]
+		if (loc.City == "" || d.province == "" || d.province == loc.City) && d.contains(lat, lng) {
+			loc.District = d.name
+			if loc.City == "" && d.province != "" { loc.City, loc.Exact = d.province, true }
+			break
+		}
+	}
+	if loc.City != "" { return loc }
+	bestKm := nearestCapitalKm
+	for _, p := range g.provinces {
+		if km := haversineKm(lat, lng, p.Lat, p.Lng); km < bestKm { loc.City, bestKm = p.Name, km }
+	}
+	return loc
+}
+
+// resolveCity picks the stored city and district for a place: boundary
+// polygons win, then the typed city if it names a province, then a nearby
+// capital. Anything else keeps the typed text, title-cased.
+func resolveCity(lat, lng float64, typed string) (city, district string) {
+	g := geo.Load()
+	if g == nil { return cases.Title(language.Turkish).String(typed), "" }
+	var loc Location
+	if validCoords(lat, lng) && !(lat == 0 && lng == 0) { loc = g.lookup(lat, lng) }
+	if loc.Exact { return loc.City, loc.District }
+	if c := g.canonicalProvince(typed); c != "" { return c, loc.District }
+	if loc.City != "" { return loc.City, loc.District }
+	return cases.Title(language.Turkish).String(strings.TrimSpace(typed)), ""
+}
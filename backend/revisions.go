@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Place revisions ---
+//
+// Every change to a place is stored in place_revisions: the editable state
+// after the change and a diff against the state before, keyed by field (or
+// field.lang for name and description). Creators, admins and the
+// edit_places rank edit directly with PUT /api/places?id=; anyone else's
+// PUT becomes a suggested edit in place_edits for moderators. A suggestion
+// carries only the fields it changes and is refused on approval if one of
+// them changed in the meantime, so nothing is overwritten blindly.
+
+var errEditConflict = errors.New("place changed since the edit was made")
+
+// invalidEditError is a diff that no longer makes a valid place once
+// applied, e.g. its category was deleted in the meantime.
+type invalidEditError string
+
+func (e invalidEditError) Error() string { return string(e) }
+
+// placeSnapshot is the editable part of a place.
+type placeSnapshot struct {
+	Name        map[string]string `json:"name"`
+	Description map[string]string `json:"description"`
+	Lat         float64           `json:"lat"`
+	Lng         float64           `json:"lng"`
+	Category    string            `json:"category"`
+	City        string            `json:"city"`
+	District    string            `json:"district,omitempty"`
+	ImageURL    string            `json:"imageUrl"`
+	PlaceAttributes
+}
+
+// fieldChange has no Old when the field was added, no New when removed.
+type fieldChange struct {
+	Old json.RawMessage `json:"old,omitempty"`
+	New json.RawMessage `json:"new,omitempty"`
+}
+
+type placeDiff map[string]fieldChange
+
+type PlaceRevision struct {
+	ID        int            `json:"id"`
+	PlaceID   int            `json:"place_id"`
+	UserID    int            `json:"user_id,omitempty"`
+	Username  string         `json:"username,omitempty"`
+	Action    string         `json:"action"` // baseline, create, edit, suggestion or rollback
+	EditID    int            `json:"edit_id,omitempty"`
+	Diff      placeDiff      `json:"diff"`
+	Data      *placeSnapshot `json:"data,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type PlaceEdit struct {
+	ID         int        `json:"id"`
+	PlaceID    int        `json:"place_id"`
+	UserID     int        `json:"user_id"`
+	Username   string     `json:"username"`
+	Changes    placeDiff  `json:"changes"`
+	Note       string     `json:"note"`
+	Status     string     `json:"status"` // pending, approved or rejected
+	Reason     string     `json:"reason,omitempty"`
+	ReviewedBy int        `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Conflicts  []string   `json:"conflicts,omitempty"` // Fields changed since, pending edits only
+}
+
+func initRevisionTables() {
+	db.Exec("ALTER TABLE places ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP")
+	db.Exec("ALTER TABLE places ALTER COLUMN updated_at SET DEFAULT CURRENT_TIMESTAMP")
+	db.Exec(`CREATE TABLE IF NOT EXISTS place_edits (
+		id SERIAL PRIMARY KEY,
+		place_id INT NOT NULL REFERENCES places(id) ON DELETE CASCADE,
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		changes JSONB NOT NULL,
+		note TEXT DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'pending',
+		reviewed_by INT REFERENCES users(id) ON DELETE SET NULL,
+		reviewed_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS place_revisions (
+		id SERIAL PRIMARY KEY,
+		place_id INT NOT NULL REFERENCES places(id) ON DELETE CASCADE,
+		user_id INT REFERENCES users(id) ON DELETE SET NULL,
+		action TEXT NOT NULL,
+		edit_id INT REFERENCES place_edits(id) ON DELETE SET NULL,
+		data JSONB NOT NULL,
+		diff JSONB NOT NULL DEFAULT '{}',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS place_revisions_place_idx ON place_revisions (place_id, id)")
+	db.Exec("ALTER TABLE place_edits ADD COLUMN IF NOT EXISTS reason TEXT")
+	db.Exec("CREATE INDEX IF NOT EXISTS place_edits_status_idx ON place_edits (status, created_at)")
+}
+
+// flattenSnapshot maps JSON field (field.lang for the localized maps) to
+// its encoded value. Encoding is deterministic, so values compare as bytes.
+func flattenSnapshot(s *placeSnapshot) map[string]json.RawMessage {
+	flat := map[string]json.RawMessage{}
+	if s == nil { return flat }
+	b, _ := json.Marshal(s)
+	json.Unmarshal(b, &flat)
+	for _, field := range []string{"name", "description"} {
+		var m map[string]json.RawMessage
+		json.Unmarshal(flat[field], &m)
+		delete(flat, field)
+		for lang, v := range m { flat[field+"."+lang] = v }
+	}
+	return flat
+}
+
+func unflattenSnapshot(flat map[string]json.RawMessage) (*placeSnapshot, error) {
+	top := map[string]interface{}{"name": map[string]json.RawMessage{}, "description": map[string]json.RawMessage{}}
+	for k, v := range flat {
+		if field, lang, ok := strings.Cut(k, "."); ok {
+			if m, ok := top[field].(map[string]json.RawMessage); ok { m[lang] = v; continue }
+		}
+		top[k] = v
+	}
+	b, err := json.Marshal(top)
+	if err != nil { return nil, err }
+	var s placeSnapshot
+	return &s, json.Unmarshal(b, &s)
+}
+
+func diffSnapshots(before, after *placeSnapshot) placeDiff {
+	a, b := flattenSnapshot(before), flattenSnapshot(after)
+	diff := placeDiff{}
+	for k, v := range a {
+		if !bytes.Equal(v, b[k]) { diff[k] = fieldChange{Old: v, New: b[k]} }
+	}
+	for k, v := range b {
+		if _, ok := a[k]; !ok { diff[k] = fieldChange{New: v} }
+	}
+	return diff
+}
+
+// overlay applies d to s. conflicts lists fields whose current value no
+// longer matches the diff's old value; they are overwritten regardless.
+func (d placeDiff) overlay(s *placeSnapshot) (*placeSnapshot, []string, error) {
+	flat := flattenSnapshot(s)
+	var conflicts []string
+	for k, c := range d {
+		if !bytes.Equal(flat[k], c.Old) { conflicts = append(conflicts, k) }
+		if c.New == nil { delete(flat, k) } else { flat[k] = c.New }
+	}
+	sort.Strings(conflicts)
+	out, err := unflattenSnapshot(flat)
+	return out, conflicts, err
+}
+
+// swapUploads moves the upload reference when a change replaced the photo.
+func (d placeDiff) swapUploads() {
+	c, ok := d["imageUrl"]
+	if !ok { return }
+	var oldURL, newURL string
+	json.Unmarshal(c.Old, &oldURL)
+	json.Unmarshal(c.New, &newURL)
+	retainUpload(newURL)
+	releaseUpload(oldURL)
+}
+
+// loadPlaceSnapshot reads the editable state and creator of a place. Inside
+// a transaction, pass lock to hold the row until commit.
+func loadPlaceSnapshot(q querier, id int, lock bool) (*placeSnapshot, int, error) {
+	var s placeSnapshot
+	var nameJSON, descJSON []byte
+	var creatorID int
+	var attrs placeAttributeScan
+	query := `SELECT p.name, p.description, p.lat, p.lng, COALESCE(p.category, ''), COALESCE(p.city, ''), COALESCE(p.district, ''), COALESCE(p.image_url, ''), COALESCE(p.creator_id, 0), ` + placeAttributeColumns + ` FROM places p WHERE p.id = $1`
+	if lock { query += " FOR UPDATE" }
+	err := q.QueryRow(query, id).Scan(append([]interface{}{&nameJSON, &descJSON, &s.Lat, &s.Lng, &s.Category, &s.City, &s.District, &s.ImageURL, &creatorID}, attrs.dest()...)...)
+	if err != nil { return nil, 0, err }
+	json.Unmarshal(nameJSON, &s.Name)
+	json.Unmarshal(descJSON, &s.Description)
+	var p Place
+	attrs.apply(&p, time.Now())
+	s.PlaceAttributes = p.PlaceAttributes
+	return &s, creatorID, nil
+}
+
+// normalizeSnapshot validates an edited snapshot and fills in what derives
+// from other fields. It returns a message for bad input.
+func normalizeSnapshot(s, before *placeSnapshot) string {
+	for _, m := range []map[string]string{s.Name, s.Description} {
+		for lang, v := range m {
+			if v = strings.TrimSpace(v); v == "" { delete(m, lang) } else { m[lang] = v }
+		}
+	}
+	if len(s.Name) == 0 { return "Name is required" }
+	if s.Lat < -90 || s.Lat > 90 || s.Lng < -180 || s.Lng > 180 { return "Invalid coordinates" }
+	category, ok := resolveCategory(s.Category)
+	if !ok { return "Unknown category" }
+	s.Category = category
+	if msg := validateAttributes(&s.PlaceAttributes); msg != "" { return msg }
+	s.District = before.District
+	if s.Lat != before.Lat || s.Lng != before.Lng || s.City != before.City {
+		s.City, s.District = resolveCity(s.Lat, s.Lng, s.City)
+	}
+	return ""
+}
+
+// savePlaceRevision writes after to the place (unless it was just created)
+// and records the revision. Nothing is recorded when nothing changed.
+// Places from before revisions existed get a baseline revision first so
+// the original state can be rolled back to.
+func savePlaceRevision(tx *sql.Tx, placeID, userID int, action string, editID int, before, after *placeSnapshot) (*PlaceRevision, error) {
+	rev := &PlaceRevision{PlaceID: placeID, UserID: userID, Action: action, EditID: editID, Diff: diffSnapshots(before, after), Data: after}
+	if before != nil {
+		if len(rev.Diff) == 0 { return rev, nil }
+		var hasHistory bool
+		tx.QueryRow("SELECT EXISTS(SELECT 1 FROM place_revisions WHERE place_id = $1)", placeID).Scan(&hasHistory)
+		if !hasHistory {
+			data, _ := json.Marshal(before)
+			if _, err := tx.Exec("INSERT INTO place_revisions (place_id, action, data) VALUES ($1, 'baseline', $2)", placeID, string(data)); err != nil { return nil, err }
+		}
+		nameJSON, _ := json.Marshal(after.Name)
+		descJSON, _ := json.Marshal(after.Description)
+		args := append([]interface{}{placeID, string(nameJSON), string(descJSON), after.Lat, after.Lng, after.Category, after.City, after.District, after.ImageURL}, attributeArgs(after.PlaceAttributes)...)
+		if _, err := tx.Exec(`UPDATE places SET name = $2, description = $3, lat = $4, lng = $5, category = $6, city = $7, district = NULLIF($8, ''), image_url = $9,
+			opening_hours = $10, timezone = $11, price_level = $12, prices = $13, amenities = $14, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, args...); err != nil { return nil, err }
+	}
+	data, _ := json.Marshal(after)
+	diff, _ := json.Marshal(rev.Diff)
+	err := tx.QueryRow("INSERT INTO place_revisions (place_id, user_id, action, edit_id, data, diff) VALUES ($1, NULLIF($2, 0), $3, NULLIF($4, 0), $5, $6) RETURNING id, created_at",
+		placeID, userID, action, editID, string(data), string(diff)).Scan(&rev.ID, &rev.CreatedAt)
+	return rev, err
+}
+
+// applyPlaceDiff applies a diff to the locked place row. Unless force is
+// set, a diff touching fields that changed since it was made fails with
+// errEditConflict and the conflicting fields. The result is validated
+// again, failing with invalidEditError.
+func applyPlaceDiff(tx *sql.Tx, placeID, userID int, action string, editID int, diff placeDiff, force bool) (*PlaceRevision, []string, error) {
+	before, _, err := loadPlaceSnapshot(tx, placeID, true)
+	if err != nil { return nil, nil, err }
+	after, conflicts, err := diff.overlay(before)
+	if err != nil { return nil, nil, err }
+	if len(conflicts) > 0 && !force { return nil, conflicts, errEditConflict }
+	if msg := normalizeSnapshot(after, before); msg != "" { return nil, nil, invalidEditError(msg) }
+	rev, err := savePlaceRevision(tx, placeID, userID, action, editID, before, after)
+	return rev, conflicts, err
+}
+
+// placeEditHandler backs PUT /api/places?id=. The body has the fields to
+// change in the shape of a place; name and description are maps and only
+// the languages given change (an empty string removes one). An optional
+// "note" explains a suggestion to moderators. Places that aren't approved
+// are only found by their creator and admins.
+func placeEditHandler(w http.ResponseWriter, r *http.Request) {
+	userID, claims := currentUser(r)
+	if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil { http.Error(w, "Invalid place ID", http.StatusBadRequest); return }
+	id = resolvePlaceID(id)
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil { http.Error(w, "Invalid body", http.StatusBadRequest); return }
+
+	before, creatorID, err := loadPlaceSnapshot(db, id, false)
+	if err == sql.ErrNoRows { http.Error(w, "Place not found", http.StatusNotFound); return }
+	if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+	if creatorID != userID && claims.Role != "admin" {
+		var status string
+		db.QueryRow("SELECT COALESCE(status, '') FROM places WHERE id = $1", id).Scan(&status)
+		if status != "approved" { http.Error(w, "Place not found", http.StatusNotFound); return }
+	}
+	var after placeSnapshot
+	b, _ := json.Marshal(before)
+	json.Unmarshal(b, &after)
+	var extra struct { Note string `json:"note"` }
+	if json.Unmarshal(body, &after) != nil || json.Unmarshal(body, &extra) != nil { http.Error(w, "Invalid body", http.StatusBadRequest); return }
+	if msg := normalizeSnapshot(&after, before); msg != "" { http.Error(w, msg, http.StatusBadRequest); return }
+	diff := diffSnapshots(before, &after)
+	if len(diff) == 0 { http.Error(w, "No changes", http.StatusBadRequest); return }
+
+	if creatorID != userID && !userHasPrivilege(userID, privilegeEditPlaces) {
+		changes, _ := json.Marshal(diff)
+		edit := PlaceEdit{PlaceID: id, UserID: userID, Changes: diff, Note: strings.TrimSpace(extra.Note), Status: "pending"}
+		err := db.QueryRow("INSERT INTO place_edits (place_id, user_id, changes, note) VALUES ($1, $2, $3, $4) RETURNING id, created_at", id, userID, string(changes), edit.Note).Scan(&edit.ID, &edit.CreatedAt)
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(edit)
+		return
+	}
+	var rev *PlaceRevision
+	var conflicts []string
+	err = withTx(func(tx *sql.Tx) error {
+		var err error
+		if rev, conflicts, err = applyPlaceDiff(tx, id, userID, "edit", 0, diff, false); err != nil { return err }
+		// Addressing requested changes puts the place back in the queue
+		_, err = tx.Exec("UPDATE places SET status = $3 WHERE id = $1 AND creator_id = $2 AND status = $4", id, userID, statusPending, statusChangesRequested)
+		return err
+	})
+	if err == errEditConflict {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "edit_conflict", "fields": conflicts})
+		return
+	}
+	var invalid invalidEditError
+	if errors.As(err, &invalid) { http.Error(w, invalid.Error(), http.StatusBadRequest); return }
+	if err != nil { log.Printf("Edit place %d: %v", id, err); http.Error(w, "Database error", http.StatusInternalServerError); return }
+	rev.Diff.swapUploads()
+	json.NewEncoder(w).Encode(rev)
+}
+
+// editConflicts lists the fields of a pending edit that changed since.
+func editConflicts(e *PlaceEdit) {
+	current, _, err := loadPlaceSnapshot(db, e.PlaceID, false)
+	if err != nil { return }
+	_, e.Conflicts, _ = e.Changes.overlay(current)
+}
+
+// revisionsAdmin handles the admin actions: GET revisions&id= (history,
+// newest first), POST rollback {place_id, revision_id}, GET edits
+// (&status=, default pending; &place_id=) and POST approve-edit or
+// reject-edit {id, reason} (approve takes "force": true to override
+// conflicts). The suggester is notified either way.
+func revisionsAdmin(w http.ResponseWriter, r *http.Request, action string) {
+	adminID, _ := currentUser(r)
+	switch {
+	case action == "revisions" && r.Method == "GET":
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil { http.Error(w, "Invalid place ID", http.StatusBadRequest); return }
+		rows, err := db.Query(`SELECT r.id, r.place_id, COALESCE(r.user_id, 0), COALESCE(u.username, ''), r.action, COALESCE(r.edit_id, 0), r.diff, r.data, r.created_at
+			FROM place_revisions r LEFT JOIN users u ON u.id = r.user_id WHERE r.place_id = $1 ORDER BY r.id DESC`, resolvePlaceID(id))
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		defer rows.Close()
+		revisions := []PlaceRevision{}
+		for rows.Next() {
+			var rev PlaceRevision
+			var diffJSON, dataJSON []byte
+			rows.Scan(&rev.ID, &rev.PlaceID, &rev.UserID, &rev.Username, &rev.Action, &rev.EditID, &diffJSON, &dataJSON, &rev.CreatedAt)
+			json.Unmarshal(diffJSON, &rev.Diff)
+			json.Unmarshal(dataJSON, &rev.Data)
+			revisions = append(revisions, rev)
+		}
+		json.NewEncoder(w).Encode(revisions)
+	case action == "rollback" && r.Method == "POST":
+		var req struct {
+			PlaceID    int `json:"place_id"`
+			RevisionID int `json:"revision_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RevisionID <= 0 { http.Error(w, "place_id and revision_id are required", http.StatusBadRequest); return }
+		req.PlaceID = resolvePlaceID(req.PlaceID)
+		var rev *PlaceRevision
+		err := withTx(func(tx *sql.Tx) error {
+			var dataJSON []byte
+			if err := tx.QueryRow("SELECT data FROM place_revisions WHERE id = $1 AND place_id = $2", req.RevisionID, req.PlaceID).Scan(&dataJSON); err != nil { return err }
+			var target placeSnapshot
+			if err := json.Unmarshal(dataJSON, &target); err != nil { return err }
+			before, _, err := loadPlaceSnapshot(tx, req.PlaceID, true)
+			if err != nil { return err }
+			// The category may have been deleted since
+			if _, ok := taxonomy.Load().bySlug[target.Category]; !ok { target.Category = before.Category }
+			rev, err = savePlaceRevision(tx, req.PlaceID, adminID, "rollback", 0, before, &target)
+			return err
+		})
+		if err == sql.ErrNoRows { http.Error(w, "Revision not found", http.StatusNotFound); return }
+		if err != nil { log.Printf("Rollback place %d: %v", req.PlaceID, err); http.Error(w, "Database error", http.StatusInternalServerError); return }
+		rev.Diff.swapUploads()
+		json.NewEncoder(w).Encode(rev)
+	case action == "edits" && r.Method == "GET":
+		status := r.URL.Query().Get("status")
+		if status == "" { status = "pending" }
+		query := `SELECT e.id, e.place_id, COALESCE(e.user_id, 0), COALESCE(u.username, ''), e.changes, COALESCE(e.note, ''), e.status, COALESCE(e.reason, ''), COALESCE(e.reviewed_by, 0), e.reviewed_at, e.created_at
+			FROM place_edits e LEFT JOIN users u ON u.id = e.user_id WHERE e.status = $1`
+		args := []interface{}{status}
+		if placeID := r.URL.Query().Get("place_id"); placeID != "" {
+			query += " AND e.place_id = $2"
+			args = append(args, placeID)
+		}
+		rows, err := db.Query(query+" ORDER BY e.created_at ASC", args...)
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		edits := []PlaceEdit{}
+		for rows.Next() {
+			var e PlaceEdit
+			var changesJSON []byte
+			rows.Scan(&e.ID, &e.PlaceID, &e.UserID, &e.Username, &changesJSON, &e.Note, &e.Status, &e.Reason, &e.ReviewedBy, &e.ReviewedAt, &e.CreatedAt)
+			json.Unmarshal(changesJSON, &e.Changes)
+			edits = append(edits, e)
+		}
+		rows.Close()
+		if status == "pending" {
+			for i := range edits { editConflicts(&edits[i]) }
+		}
+		json.NewEncoder(w).Encode(edits)
+	case (action == "approve-edit" || action == "reject-edit") && r.Method == "POST":
+		var req struct {
+			ID     int    `json:"id"`
+			Force  bool   `json:"force"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID <= 0 { http.Error(w, "id is required", http.StatusBadRequest); return }
+		var rev *PlaceRevision
+		var conflicts []string
+		err := withTx(func(tx *sql.Tx) error {
+			var placeID, userID int
+			var changesJSON []byte
+			if err := tx.QueryRow("SELECT place_id, COALESCE(user_id, 0), changes FROM place_edits WHERE id = $1 AND status = 'pending' FOR UPDATE", req.ID).Scan(&placeID, &userID, &changesJSON); err != nil { return err }
+			status := "rejected"
+			if action == "approve-edit" {
+				var diff placeDiff
+				json.Unmarshal(changesJSON, &diff)
+				var err error
+				if rev, conflicts, err = applyPlaceDiff(tx, placeID, userID, "suggestion", req.ID, diff, req.Force); err != nil { return err }
+				status = "approved"
+			}
+			if _, err := tx.Exec("UPDATE place_edits SET status = $2, reviewed_by = $3, reviewed_at = CURRENT_TIMESTAMP, reason = NULLIF($4, '') WHERE id = $1", req.ID, status, adminID, strings.TrimSpace(req.Reason)); err != nil { return err }
+			return notify(tx, userID, "edit_reviewed", refPlace, placeID, map[string]interface{}{"edit_id": req.ID, "status": status, "reason": strings.TrimSpace(req.Reason)})
+		})
+		if err == sql.ErrNoRows { http.Error(w, "Pending edit not found", http.StatusNotFound); return }
+		if err == errEditConflict {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": "edit_conflict", "fields": conflicts})
+			return
+		}
+		var invalid invalidEditError
+		if errors.As(err, &invalid) { http.Error(w, "Edit no longer valid: "+invalid.Error(), http.StatusBadRequest); return }
+		if err != nil { log.Printf("Review edit %d: %v", req.ID, err); http.Error(w, "Database error", http.StatusInternalServerError); return }
+		if rev == nil {
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": req.ID, "status": "rejected"})
+			return
+		}
+		rev.Diff.swapUploads()
+		json.NewEncoder(w).Encode(rev)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// --- Saved routes ---
+//
+// A route is an ordered list of places owned by a user. Private routes are
+// visible to their owner only, unlisted ones to anyone holding the share
+// slug, and public ones are listed for everybody.
+
+const maxRouteStops = 50
+
+var routeVisibilities = map[string]bool{"private": true, "unlisted": true, "public": true}
+
+var errUnknownPlace = errors.New("place not found or not approved")
+
+type Route struct {
+	ID          int       `json:"id"`
+	OwnerID     int       `json:"owner_id"`
+	Owner       string    `json:"owner"`
+	Title       string    `json:"title"`
+	Notes       string    `json:"notes"`
+	Visibility  string    `json:"visibility"`
+	ShareSlug   string    `json:"share_slug,omitempty"` // Only shown to the owner
+	ForkedFrom  *int      `json:"forked_from,omitempty"`
+	PlaceIDs    []int     `json:"place_ids"`
+	Stops       []Place   `json:"stops"`
+	HiddenStops int       `json:"hidden_stops,omitempty"` // Stops whose place is no longer approved; left out of PlaceIDs and Stops
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func initRouteTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS routes (
+		id SERIAL PRIMARY KEY,
+		owner_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		title TEXT NOT NULL,
+		notes TEXT DEFAULT '',
+		visibility TEXT NOT NULL DEFAULT 'private',
+		share_slug TEXT UNIQUE NOT NULL,
+		forked_from INT REFERENCES routes(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+	db.Exec(`CREATE TABLE IF NOT EXISTS route_stops (
+		route_id INT NOT NULL REFERENCES routes(id) ON DELETE CASCADE,
+		position INT NOT NULL,
+		place_id INT NOT NULL REFERENCES places(id) ON DELETE CASCADE,
+		PRIMARY KEY (route_id, position)
+	)`)
+	db.Exec("CREATE INDEX IF NOT EXISTS routes_owner ON routes (owner_id)")
+}
+
+// shareSlug is an unguessable URL-safe token (96 random bits).
+func shareSlug() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// localizedName picks lang from a JSONB name, falling back to Turkish and
+// then to any translation.
+func localizedName(name map[string]string, lang string) string {
+	if s := name[lang]; s != "" { return s }
+	if s := name["tr"]; s != "" { return s }
+	for _, s := range name { return s }
+	return ""
+}
+
+// loadRoute fetches a route by "id" or "share_slug" with its approved
+// stops. Stops whose place was rejected or archived since are only counted,
+// so saving or forking the route drops them.
+func loadRoute(column string, value interface{}) (*Route, error) {
+	var rt Route
+	var forked sql.NullInt64
+	err := db.QueryRow(`SELECT r.id, r.owner_id, u.username, r.title, COALESCE(r.notes, ''), r.visibility, r.share_slug, r.forked_from, r.created_at, r.updated_at
+		FROM routes r JOIN users u ON u.id = r.owner_id WHERE r.`+column+` = $1`, value).
+		Scan(&rt.ID, &rt.OwnerID, &rt.Owner, &rt.Title, &rt.Notes, &rt.Visibility, &rt.ShareSlug, &forked, &rt.CreatedAt, &rt.UpdatedAt)
+	if err != nil { return nil, err }
+	if forked.Valid {
+		id := int(forked.Int64)
+		rt.ForkedFrom = &id
+	}
+	db.QueryRow("SELECT COUNT(*) FROM route_stops s JOIN places p ON p.id = s.place_id WHERE s.route_id = $1 AND p.status <> 'approved'", rt.ID).Scan(&rt.HiddenStops)
+	rows, err := db.Query(`SELECT p.id, p.name, p.description, p.lat, p.lng, p.category, p.city, COALESCE(p.image_url, ''), p.status
+		FROM route_stops s JOIN places p ON p.id = s.place_id WHERE s.route_id = $1 AND p.status = 'approved' ORDER BY s.position`, rt.ID)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	rt.PlaceIDs, rt.Stops = []int{}, []Place{}
+	for rows.Next() {
+		var p Place
+		var nameJSON, descJSON []byte
+		rows.Scan(&p.ID, &nameJSON, &descJSON, &p.Lat, &p.Lng, &p.Category, &p.City, &p.ImageURL, &p.Status)
+		json.Unmarshal(nameJSON, &p.Name)
+		json.Unmarshal(descJSON, &p.Description)
+		p.Images = imageVariants(p.ImageURL)
+		rt.PlaceIDs = append(rt.PlaceIDs, p.ID)
+		rt.Stops = append(rt.Stops, p)
+	}
+	return &rt, nil
+}
+
+// listRoutes returns routes without stops; owner 0 lists public routes.
+func listRoutes(ownerID int) ([]Route, error) {
+	query := `SELECT r.id, r.owner_id, u.username, r.title, COALESCE(r.notes, ''), r.visibility, r.share_slug, r.created_at, r.updated_at,
+			ARRAY(SELECT s.place_id FROM route_stops s JOIN places p ON p.id = s.place_id WHERE s.route_id = r.id AND p.status = 'approved' ORDER BY s.position)
+		FROM routes r JOIN users u ON u.id = r.owner_id`
+	var rows *sql.Rows
+	var err error
+	if ownerID > 0 {
+		rows, err = db.Query(query+" WHERE r.owner_id = $1 ORDER BY r.updated_at DESC", ownerID)
+	} else {
+		rows, err = db.Query(query + " WHERE r.visibility = 'public' ORDER BY r.updated_at DESC LIMIT 100")
+	}
+	if err != nil { return nil, err }
+	defer rows.Close()
+	routes := []Route{}
+	for rows.Next() {
+		var rt Route
+		var ids pq.Int64Array
+		rows.Scan(&rt.ID, &rt.OwnerID, &rt.Owner, &rt.Title, &rt.Notes, &rt.Visibility, &rt.ShareSlug, &rt.CreatedAt, &rt.UpdatedAt, &ids)
+		rt.PlaceIDs = []int{}
+		for _, id := range ids { rt.PlaceIDs = append(rt.PlaceIDs, int(id)) }
+		if ownerID == 0 { rt.ShareSlug = "" }
+		routes = append(routes, rt)
+	}
+	return routes, nil
+}
+
+// saveStops replaces a route's stops. Only approved places can be added.
+func saveStops(tx *sql.Tx, routeID int, placeIDs []int) error {
+	if _, err := tx.Exec("DELETE FROM route_stops WHERE route_id = $1", routeID); err != nil { return err }
+	for i, id := range placeIDs {
+		res, err := tx.Exec("INSERT INTO route_stops (route_id, position, place_id) SELECT $1, $2, id FROM places WHERE id = $3 AND status = 'approved'", routeID, i, id)
+		if err != nil { return err }
+		if n, _ := res.RowsAffected(); n == 0 { return fmt.Errorf("%w: %d", errUnknownPlace, id) }
+	}
+	return nil
+}
+
+// validateRoute normalises a create/update payload.
+func validateRoute(rt *Route) string {
+	rt.Title = strings.TrimSpace(rt.Title)
+	if rt.Title == "" || len(rt.Title) > 200 { return "Title is required (max 200 characters)" }
+	if len(rt.Notes) > 5000 { return "Notes are too long" }
+	if rt.Visibility == "" { rt.Visibility = "private" }
+	if !routeVisibilities[rt.Visibility] { return "Invalid visibility" }
+	if len(rt.PlaceIDs) == 0 || len(rt.PlaceIDs) > maxRouteStops { return fmt.Sprintf("A route needs 1 to %d places", maxRouteStops) }
+	return ""
+}
+
+// createRoute inserts rt for ownerID and returns the new ID.
+func createRoute(ownerID int, rt Route, forkedFrom *int) (int, error) {
+	var id int
+	err := withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow("INSERT INTO routes (owner_id, title, notes, visibility, share_slug, forked_from) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+			ownerID, rt.Title, rt.Notes, rt.Visibility, shareSlug(), forkedFrom).Scan(&id)
+		if err != nil { return err }
+		return saveStops(tx, id, rt.PlaceIDs)
+	})
+	return id, err
+}
+
+// canView reports whether userID may open rt by ID (bySlug false) or via
+// its share link (bySlug true).
+func (rt *Route) canView(userID int, isAdmin, bySlug bool) bool {
+	if rt.OwnerID == userID || isAdmin || rt.Visibility == "public" { return true }
+	return bySlug && rt.Visibility == "unlisted"
+}
+
+// --- GPX / KML export ---
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Name string  `xml:"name"`
+	Desc string  `xml:"desc,omitempty"`
+}
+
+type gpxDoc struct {
+	XMLName   xml.Name   `xml:"gpx"`
+	Version   string     `xml:"version,attr"`
+	Creator   string     `xml:"creator,attr"`
+	Xmlns     string     `xml:"xmlns,attr"`
+	Name      string     `xml:"metadata>name"`
+	Waypoints []gpxPoint `xml:"wpt"`
+	Route     struct {
+		Name   string     `xml:"name"`
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+func writeRouteGPX(w http.ResponseWriter, rt *Route, lang string) error {
+	doc := gpxDoc{Version: "1.1", Creator: "Maplas", Xmlns: "http://www.topografix.com/GPX/1/1", Name: rt.Title}
+	doc.Route.Name = rt.Title
+	for _, p := range rt.Stops {
+		pt := gpxPoint{Lat: p.Lat, Lon: p.Lng, Name: localizedName(p.Name, lang), Desc: localizedName(p.Description, lang)}
+		doc.Waypoints = append(doc.Waypoints, pt)
+		doc.Route.Points = append(doc.Route.Points, pt)
+	}
+	w.Header().Set("Content-Type", "application/gpx+xml")
+	w.Write([]byte(xml.Header))
+	return xml.NewEncoder(w).Encode(doc)
+}
+
+type kmlPlacemark struct {
+	Name        string `xml:"name"`
+	Description string `xml:"description,omitempty"`
+	Point       *struct {
+		Coordinates string `xml:"coordinates"`
+	} `xml:"Point,omitempty"`
+	LineString *struct {
+		Tessellate  int    `xml:"tessellate"`
+		Coordinates string `xml:"coordinates"`
+	} `xml:"LineString,omitempty"`
+	Data []kmlData `xml:"ExtendedData>Data,omitempty"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlDoc struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Xmlns      string         `xml:"xmlns,attr"`
+	Name       string         `xml:"Document>name"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+// kmlCoord formats a KML lng,lat coordinate tuple.
+func kmlCoord(lat, lng float64) string {
+	return strconv.FormatFloat(lng, 'f', -1, 64) + "," + strconv.FormatFloat(lat, 'f', -1, 64)
+}
+
+func writeRouteKML(w http.ResponseWriter, rt *Route, lang string) error {
+	doc := kmlDoc{Xmlns: "http://www.opengis.net/kml/2.2", Name: rt.Title}
+	var line []string
+	for _, p := range rt.Stops {
+		pm := kmlPlacemark{Name: localizedName(p.Name, lang), Description: localizedName(p.Description, lang)}
+		pm.Point = &struct {
+			Coordinates string `xml:"coordinates"`
+		}{kmlCoord(p.Lat, p.Lng)}
+		doc.Placemarks = append(doc.Placemarks, pm)
+		line = append(line, kmlCoord(p.Lat, p.Lng))
+	}
+	if len(line) > 1 {
+		pm := kmlPlacemark{Name: rt.Title}
+		pm.LineString = &struct {
+			Tessellate  int    `xml:"tessellate"`
+			Coordinates string `xml:"coordinates"`
+		}{1, strings.Join(line, " ")}
+		doc.Placemarks = append(doc.Placemarks, pm)
+	}
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Write([]byte(xml.Header))
+	return xml.NewEncoder(w).Encode(doc)
+}
+
+// routesHandler serves /api/routes:
+//   GET                    the caller's routes (?public=1 lists public routes)
+//   GET    ?id= | ?slug=   one route; add format=gpx|kml (and lang) to export
+//   POST                   create {"title", "notes", "visibility", "place_ids"}
+//   POST   ?action=fork&id= | &slug=   copy a visible route to the caller
+//   PUT    ?id=            update (owner)
+//   DELETE ?id=            delete (owner or admin)
+func routesHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	userID, claims := currentUser(r)
+	isAdmin := claims != nil && claims.Role == "admin"
+	q := r.URL.Query()
+
+	// Resolve ?id= or ?slug= to a route the caller may see
+	var rt *Route
+	if q.Get("id") != "" || q.Get("slug") != "" {
+		var err error
+		bySlug := q.Get("id") == ""
+		if bySlug {
+			rt, err = loadRoute("share_slug", q.Get("slug"))
+		} else if id, convErr := strconv.Atoi(q.Get("id")); convErr == nil {
+			rt, err = loadRoute("id", id)
+		} else {
+			http.Error(w, "Invalid route ID", http.StatusBadRequest)
+			return
+		}
+		if err == sql.ErrNoRows || (err == nil && !rt.canView(userID, isAdmin, bySlug)) { http.Error(w, "Route not found", http.StatusNotFound); return }
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		if rt.OwnerID != userID { rt.ShareSlug = "" }
+	}
+
+	switch r.Method {
+	case "GET":
+		if rt == nil {
+			if q.Get("public") != "" {
+				userID = 0
+			} else if userID == 0 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			routes, err := listRoutes(userID)
+			if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+			json.NewEncoder(w).Encode(routes)
+			return
+		}
+		filename := fmt.Sprintf("attachment; filename=\"route-%d.%s\"", rt.ID, q.Get("format"))
+		switch q.Get("format") {
+		case "gpx":
+			w.Header().Set("Content-Disposition", filename)
+			writeRouteGPX(w, rt, q.Get("lang"))
+		case "kml":
+			w.Header().Set("Content-Disposition", filename)
+			writeRouteKML(w, rt, q.Get("lang"))
+		case "", "json":
+			json.NewEncoder(w).Encode(rt)
+		default:
+			http.Error(w, "Unknown format", http.StatusBadRequest)
+		}
+	case "POST":
+		if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+		var req Route
+		var forkedFrom *int
+		if q.Get("action") == "fork" {
+			if rt == nil { http.Error(w, "Route not found", http.StatusNotFound); return }
+			req = Route{Title: rt.Title, Notes: rt.Notes, Visibility: "private", PlaceIDs: rt.PlaceIDs}
+			forkedFrom = &rt.ID
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if msg := validateRoute(&req); msg != "" { http.Error(w, msg, http.StatusBadRequest); return }
+		id, err := createRoute(userID, req, forkedFrom)
+		if errors.Is(err, errUnknownPlace) { http.Error(w, err.Error(), http.StatusBadRequest); return }
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		created, err := loadRoute("id", id)
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	case "PUT":
+		if rt == nil || rt.OwnerID != userID { http.Error(w, "Route not found", http.StatusNotFound); return }
+		var req Route
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil { http.Error(w, "Invalid request", http.StatusBadRequest); return }
+		if msg := validateRoute(&req); msg != "" { http.Error(w, msg, http.StatusBadRequest); return }
+		err := withTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec("UPDATE routes SET title = $1, notes = $2, visibility = $3, updated_at = CURRENT_TIMESTAMP WHERE id = $4", req.Title, req.Notes, req.Visibility, rt.ID); err != nil { return err }
+			return saveStops(tx, rt.ID, req.PlaceIDs)
+		})
+		if errors.Is(err, errUnknownPlace) { http.Error(w, err.Error(), http.StatusBadRequest); return }
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		updated, err := loadRoute("id", rt.ID)
+		if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+		json.NewEncoder(w).Encode(updated)
+	case "DELETE":
+		if rt == nil || (rt.OwnerID != userID && !isAdmin) { http.Error(w, "Route not found", http.StatusNotFound); return }
+		db.Exec("DELETE FROM routes WHERE id = $1", rt.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
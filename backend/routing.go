@@ -0,0 +1,390 @@
+package main
+
+import (
+	"container/heap"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// --- Offline routing ---
+//
+// ROUTING_PBF points at an OSM .osm.pbf extract. At startup it is loaded
+// into an in-memory road graph (junctions as vertices, road stretches
+// between them as edges) with car and walk travel times per edge. Queries
+// snap points to the nearest routable vertex and run A* (point to point)
+// or a bounded Dijkstra (one to many). Until the graph is loaded, or when
+// a point can't be snapped, callers fall back to straight-line estimates.
+
+var routingPBF = getEnv("ROUTING_PBF", "")
+var routingSnapKm = envFloat("ROUTING_SNAP_KM", 3) // Max distance from a point to the road network
+
+const (
+	profileCar  = "car"
+	profileWalk = "walk"
+)
+
+const walkSpeedKmh = 4.5
+
+// accessSpeedKmh covers the stretch between a point and its snapped vertex.
+var accessSpeedKmh = map[string]float64{profileCar: 20, profileWalk: walkSpeedKmh}
+
+// carSpeedKmh are typical speeds per highway class; maxspeed tags can only
+// lower them.
+var carSpeedKmh = map[string]float64{
+	"motorway": 100, "motorway_link": 60, "trunk": 80, "trunk_link": 50,
+	"primary": 65, "primary_link": 40, "secondary": 55, "secondary_link": 35,
+	"tertiary": 45, "tertiary_link": 30, "unclassified": 35, "residential": 25,
+	"living_street": 10, "service": 15, "road": 30, "track": 15,
+}
+
+// walkOnly are highway classes closed to cars but open to pedestrians.
+var walkOnly = map[string]bool{"footway": true, "path": true, "pedestrian": true, "steps": true, "cycleway": true, "bridleway": true}
+
+var roads atomic.Pointer[RoadGraph]
+
+// RoadGraph is an immutable adjacency (CSR) graph. Edge times are seconds,
+// negative when the profile may not use the edge.
+type RoadGraph struct {
+	lat, lng []float64
+	first    []int32 // Edges of v are first[v]..first[v+1]
+	to       []int32
+	meters   []float32
+	car      []float32
+	walk     []float32
+	grid     map[[2]int32][]int32
+	maxCarMS float64 // Fastest edge, keeps the A* heuristic admissible
+}
+
+type travelResult struct {
+	km, minutes float64
+	ok          bool
+}
+
+const gridCellDeg = 0.01
+
+func gridCell(lat, lng float64) [2]int32 {
+	return [2]int32{int32(math.Floor(lat / gridCellDeg)), int32(math.Floor(lng / gridCellDeg))}
+}
+
+type pendingEdge struct {
+	from, to  int32
+	meters    float32
+	car, walk float32
+}
+
+type routableWay struct {
+	refs          []int64
+	carKmh        float64 // 0 when closed to cars
+	walk          bool
+	forward, back bool // Car directions allowed
+}
+
+// classifyWay derives car/walk access from OSM tags, or nil when the way
+// isn't routable.
+func classifyWay(tags map[string]string) *routableWay {
+	highway := tags["highway"]
+	w := &routableWay{forward: true, back: true}
+	if speed, ok := carSpeedKmh[highway]; ok {
+		w.carKmh = speed
+		w.walk = !strings.HasPrefix(highway, "motorway") && !strings.HasPrefix(highway, "trunk")
+	} else if walkOnly[highway] {
+		w.walk = true
+	} else {
+		return nil
+	}
+	if max, err := strconv.ParseFloat(strings.TrimSuffix(tags["maxspeed"], " km/h"), 64); err == nil && max > 0 && max < w.carKmh { w.carKmh = max }
+	switch tags["access"] {
+	case "no", "private":
+		w.carKmh, w.walk = 0, false
+	}
+	if tags["motor_vehicle"] == "no" || tags["motorcar"] == "no" { w.carKmh = 0 }
+	switch tags["foot"] {
+	case "no":
+		w.walk = false
+	case "yes", "designated", "permissive":
+		w.walk = true
+	}
+	oneway := tags["oneway"]
+	if oneway == "" && (tags["junction"] == "roundabout" || highway == "motorway") { oneway = "yes" }
+	switch oneway {
+	case "yes", "1", "true":
+		w.back = false
+	case "-1", "reverse":
+		w.forward = false
+	}
+	if w.carKmh == 0 && !w.walk { return nil }
+	return w
+}
+
+// loadRoadGraph builds the graph in two passes over the extract: ways
+// first to learn which nodes matter, then only those nodes' coordinates.
+func loadRoadGraph(path string) (*RoadGraph, error) {
+	var ways []*routableWay
+	uses := map[int64]uint8{}
+	err := readPBF(path, pbfHandler{Way: func(w osmWay) {
+		rw := classifyWay(w.Tags)
+		if rw == nil || len(w.Refs) < 2 { return }
+		rw.refs = w.Refs
+		ways = append(ways, rw)
+		for i, ref := range w.Refs {
+			if uses[ref] < 2 { uses[ref]++ }
+			// Way ends are always vertices
+			if i == 0 || i == len(w.Refs)-1 { uses[ref] = 2 }
+		}
+	}})
+	if err != nil { return nil, err }
+
+	coords := make(map[int64][2]float64, len(uses))
+	err = readPBF(path, pbfHandler{Node: func(id int64, lat, lng float64) {
+		if _, ok := uses[id]; ok { coords[id] = [2]float64{lat, lng} }
+	}})
+	if err != nil { return nil, err }
+
+	g := &RoadGraph{grid: map[[2]int32][]int32{}}
+	vertex := map[int64]int32{}
+	vertexOf := func(ref int64) int32 {
+		if v, ok := vertex[ref]; ok { return v }
+		v := int32(len(g.lat))
+		vertex[ref] = v
+		c := coords[ref]
+		g.lat, g.lng = append(g.lat, c[0]), append(g.lng, c[1])
+		return v
+	}
+	var edges []pendingEdge
+	for _, w := range ways {
+		start, meters := int32(-1), 0.0
+		var prev [2]float64
+		for _, ref := range w.refs {
+			c, ok := coords[ref]
+			if !ok {
+				// Node missing from the extract, drop the broken stretch
+				start = -1
+				continue
+			}
+			if start >= 0 { meters += haversineKm(prev[0], prev[1], c[0], c[1]) * 1000 }
+			prev = c
+			if uses[ref] < 2 {
+				continue
+			}
+			v := vertexOf(ref)
+			if start >= 0 && start != v {
+				carSec, walkSec := float32(-1), float32(-1)
+				if w.carKmh > 0 { carSec = float32(meters / (w.carKmh / 3.6)) }
+				if w.walk { walkSec = float32(meters / (walkSpeedKmh / 3.6)) }
+				fwd, back := carSec, carSec
+				if !w.forward { fwd = -1 }
+				if !w.back { back = -1 }
+				if fwd >= 0 || walkSec >= 0 { edges = append(edges, pendingEdge{start, v, float32(meters), fwd, walkSec}) }
+				if back >= 0 || walkSec >= 0 { edges = append(edges, pendingEdge{v, start, float32(meters), back, walkSec}) }
+				if w.carKmh/3.6 > g.maxCarMS { g.maxCarMS = w.carKmh / 3.6 }
+			}
+			start, meters = v, 0
+		}
+	}
+
+	sort.Slice(edges, func(i, j int) bool { return edges[i].from < edges[j].from })
+	g.first = make([]int32, len(g.lat)+1)
+	for _, e := range edges { g.first[e.from+1]++ }
+	for i := 1; i < len(g.first); i++ { g.first[i] += g.first[i-1] }
+	g.to = make([]int32, 0, len(edges))
+	g.meters = make([]float32, 0, len(edges))
+	g.car = make([]float32, 0, len(edges))
+	g.walk = make([]float32, 0, len(edges))
+	for _, e := range edges {
+		g.to = append(g.to, e.to)
+		g.meters = append(g.meters, e.meters)
+		g.car = append(g.car, e.car)
+		g.walk = append(g.walk, e.walk)
+	}
+	for v := range g.lat {
+		cell := gridCell(g.lat[v], g.lng[v])
+		g.grid[cell] = append(g.grid[cell], int32(v))
+	}
+	if g.maxCarMS == 0 { g.maxCarMS = 1 }
+	return g, nil
+}
+
+func (g *RoadGraph) edgeSeconds(profile string, e int32) float32 {
+	if profile == profileWalk { return g.walk[e] }
+	return g.car[e]
+}
+
+// usable reports whether v has an outgoing edge open to profile.
+func (g *RoadGraph) usable(profile string, v int32) bool {
+	for e := g.first[v]; e < g.first[v+1]; e++ {
+		if g.edgeSeconds(profile, e) >= 0 { return true }
+	}
+	return false
+}
+
+// snap finds the nearest usable vertex within routingSnapKm.
+func (g *RoadGraph) snap(profile string, lat, lng float64) (int32, float64, bool) {
+	center := gridCell(lat, lng)
+	best, bestKm := int32(-1), math.Inf(1)
+	maxRing := int32(math.Ceil(routingSnapKm/(gridCellDeg*111*math.Max(0.1, math.Cos(lat*math.Pi/180))))) + 1
+	for ring := int32(0); ring <= maxRing; ring++ {
+		// Cells in this ring are at least (ring-1) cells away
+		if best >= 0 && float64(ring-1)*gridCellDeg*111*math.Cos(lat*math.Pi/180) > bestKm { break }
+		for dy := -ring; dy <= ring; dy++ {
+			for dx := -ring; dx <= ring; dx++ {
+				if dy != -ring && dy != ring && dx != -ring && dx != ring { continue }
+				for _, v := range g.grid[[2]int32{center[0] + dy, center[1] + dx}] {
+					if d := haversineKm(lat, lng, g.lat[v], g.lng[v]); d < bestKm && g.usable(profile, v) { best, bestKm = v, d }
+				}
+			}
+		}
+	}
+	return best, bestKm, best >= 0 && bestKm <= routingSnapKm
+}
+
+type searchItem struct {
+	v        int32
+	priority float64
+}
+
+type searchQueue []searchItem
+
+func (q searchQueue) Len() int            { return len(q) }
+func (q searchQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q searchQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *searchQueue) Push(x interface{}) { *q = append(*q, x.(searchItem)) }
+func (q *searchQueue) Pop() interface{} {
+	old := *q
+	it := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return it
+}
+
+type searchLabel struct{ sec, meters float64 }
+
+// search runs Dijkstra from src, or A* towards dst when dst >= 0. It stops
+// once dst or every target is settled, or when costs exceed cutoff seconds.
+func (g *RoadGraph) search(profile string, src, dst int32, targets map[int32]bool, cutoff float64) map[int32]searchLabel {
+	speed := g.maxCarMS
+	if profile == profileWalk { speed = walkSpeedKmh / 3.6 }
+	h := func(v int32) float64 {
+		if dst < 0 { return 0 }
+		return haversineKm(g.lat[v], g.lng[v], g.lat[dst], g.lng[dst]) * 1000 / speed
+	}
+	labels := map[int32]searchLabel{src: {}}
+	settled := map[int32]bool{}
+	remaining := len(targets)
+	q := &searchQueue{{src, h(src)}}
+	for q.Len() > 0 {
+		it := heap.Pop(q).(searchItem)
+		if settled[it.v] { continue }
+		settled[it.v] = true
+		cur := labels[it.v]
+		if cur.sec > cutoff || it.v == dst { break }
+		if targets[it.v] {
+			if remaining--; remaining == 0 { break }
+		}
+		for e := g.first[it.v]; e < g.first[it.v+1]; e++ {
+			sec := g.edgeSeconds(profile, e)
+			if sec < 0 { continue }
+			next := g.to[e]
+			l, seen := labels[next]
+			if cost := cur.sec + float64(sec); !seen || cost < l.sec {
+				labels[next] = searchLabel{cost, cur.meters + float64(g.meters[e])}
+				heap.Push(q, searchItem{next, cost + h(next)})
+			}
+		}
+	}
+	for v := range labels {
+		if !settled[v] { delete(labels, v) }
+	}
+	return labels
+}
+
+// travelMany routes from one point to many. Destinations that can't be
+// snapped or reached within cutoffMinutes come back with ok false.
+func (g *RoadGraph) travelMany(profile string, lat, lng float64, dests [][2]float64, cutoffMinutes float64) []travelResult {
+	results := make([]travelResult, len(dests))
+	src, srcKm, ok := g.snap(profile, lat, lng)
+	if !ok { return results }
+	access := accessSpeedKmh[profile]
+	snapped := make([]int32, len(dests))
+	accessKm := make([]float64, len(dests))
+	targets := map[int32]bool{}
+	for i, d := range dests {
+		v, km, ok := g.snap(profile, d[0], d[1])
+		snapped[i], accessKm[i] = -1, km
+		if ok {
+			snapped[i] = v
+			targets[v] = true
+		}
+	}
+	labels := g.search(profile, src, -1, targets, cutoffMinutes*60)
+	for i := range dests {
+		if snapped[i] < 0 { continue }
+		l, ok := labels[snapped[i]]
+		if !ok { continue }
+		km := srcKm + l.meters/1000 + accessKm[i]
+		minutes := l.sec/60 + (srcKm+accessKm[i])/access*60
+		if minutes > cutoffMinutes { continue }
+		results[i] = travelResult{km, minutes, true}
+	}
+	return results
+}
+
+// travel routes between two points with A*.
+func (g *RoadGraph) travel(profile string, lat1, lng1, lat2, lng2 float64) travelResult {
+	src, srcKm, ok1 := g.snap(profile, lat1, lng1)
+	dst, dstKm, ok2 := g.snap(profile, lat2, lng2)
+	if !ok1 || !ok2 { return travelResult{} }
+	l, ok := g.search(profile, src, dst, nil, math.Inf(1))[dst]
+	if !ok { return travelResult{} }
+	access := accessSpeedKmh[profile]
+	return travelResult{srcKm + l.meters/1000 + dstKm, l.sec/60 + (srcKm+dstKm)/access*60, true}
+}
+
+func validProfile(profile string) bool { return profile == profileCar || profile == profileWalk }
+
+// startRoutingGraph loads ROUTING_PBF in the background, if configured.
+func startRoutingGraph() {
+	if routingPBF == "" { return }
+	go func() {
+		started := time.Now()
+		g, err := loadRoadGraph(routingPBF)
+		if err != nil {
+			log.Printf("Routing: failed to load %s: %v", routingPBF, err)
+			return
+		}
+		roads.Store(g)
+		log.Printf("Routing: loaded %d vertices, %d edges from %s in %s", len(g.lat), len(g.to), routingPBF, time.Since(started).Round(time.Second))
+	}()
+}
+
+// withTravelTimes annotates nearby search results with road travel from
+// lat/lng and orders them by it. With maxMinutes, places beyond it (or
+// unreachable) are dropped. Without a loaded graph places are unchanged.
+func withTravelTimes(places []Place, profile, latStr, lngStr, maxMinutes string) []Place {
+	g := roads.Load()
+	lat, err1 := strconv.ParseFloat(latStr, 64)
+	lng, err2 := strconv.ParseFloat(lngStr, 64)
+	if g == nil || err1 != nil || err2 != nil { return places }
+	cutoff, err := strconv.ParseFloat(maxMinutes, 64)
+	if err != nil || cutoff <= 0 { cutoff = 24 * 60 }
+	dests := make([][2]float64, len(places))
+	for i, p := range places { dests[i] = [2]float64{p.Lat, p.Lng} }
+	kept := places[:0]
+	for i, res := range g.travelMany(profile, lat, lng, dests, cutoff) {
+		if res.ok {
+			km, minutes := math.Round(res.km*10)/10, math.Round(res.minutes)
+			places[i].TravelKm, places[i].TravelMinutes = &km, &minutes
+		} else if maxMinutes != "" {
+			continue
+		}
+		kept = append(kept, places[i])
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		a, b := kept[i].TravelMinutes, kept[j].TravelMinutes
+		return a != nil && (b == nil || *a < *b)
+	})
+	return kept
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// A square of roads with a footpath across it:
+//
+//	D --secondary-- C
+//	|             / |
+//	res.    footway  res.
+//	(oneway)  /     |
+//	A ---primary--- B   (through an extra node E that is not a junction)
+func TestRoadGraphSearch(t *testing.T) {
+	nodes := []fixtureNode{{1, 41.00, 29.00}, {2, 41.00, 29.01}, {3, 41.01, 29.01}, {4, 41.01, 29.00}, {5, 41.00, 29.005}}
+	ways := []fixtureWay{
+		{10, [][2]string{{"highway", "primary"}}, []int64{1, 5, 2}},
+		{11, [][2]string{{"highway", "residential"}}, []int64{2, 3}},
+		{12, [][2]string{{"highway", "residential"}, {"oneway", "yes"}}, []int64{1, 4}},
+		{13, [][2]string{{"highway", "secondary"}}, []int64{4, 3}},
+		{14, [][2]string{{"highway", "footway"}}, []int64{1, 3}},
+	}
+	g, err := loadRoadGraph(writePBFFixture(t, nodes, ways))
+	if err != nil { t.Fatal(err) }
+	if len(g.lat) != 4 { t.Fatalf("got %d vertices, want 4 (E is not a junction)", len(g.lat)) }
+	vertex := func(lat, lng float64) int32 {
+		for v := range g.lat {
+			if math.Abs(g.lat[v]-lat) < 1e-9 && math.Abs(g.lng[v]-lng) < 1e-9 { return int32(v) }
+		}
+		t.Fatalf("no vertex at %v,%v", lat, lng)
+		return -1
+	}
+	a, c, d := vertex(41.00, 29.00), vertex(41.01, 29.01), vertex(41.01, 29.00)
+
+	for _, profile := range []string{profileCar, profileWalk} {
+		for src := int32(0); src < 4; src++ {
+			dijkstra := g.search(profile, src, -1, nil, math.Inf(1))
+			if len(dijkstra) != 4 { t.Errorf("%s from %d: reached %d vertices, want 4", profile, src, len(dijkstra)) }
+			for dst := int32(0); dst < 4; dst++ {
+				astar, ok := g.search(profile, src, dst, nil, math.Inf(1))[dst]
+				want := dijkstra[dst]
+				if !ok || math.Abs(astar.sec-want.sec) > 1e-6 || math.Abs(astar.meters-want.meters) > 1e-6 {
+					t.Errorf("%s %d->%d: A* = %+v (%v), Dijkstra = %+v", profile, src, dst, astar, ok, want)
+				}
+			}
+		}
+	}
+
+	side, diagonal := haversineKm(41, 29, 41, 29.01)*1000, haversineKm(41, 29, 41.01, 29.01)*1000
+	north := haversineKm(41, 29, 41.01, 29)*1000
+	// Cars can't take the footway and go A-B-C, faster than A-D-C
+	if l := g.search(profileCar, a, c, nil, math.Inf(1))[c]; math.Abs(l.meters-(side+north)) > 1 || math.Abs(l.sec-(side/(65/3.6)+north/(25/3.6))) > 0.1 {
+		t.Errorf("car A->C = %+v", l)
+	}
+	if l := g.search(profileWalk, a, c, nil, math.Inf(1))[c]; math.Abs(l.meters-diagonal) > 1 { t.Errorf("walk A->C = %+v, want the %.0f m footway", l, diagonal) }
+	// The oneway A->D can only be walked backwards
+	if l := g.search(profileCar, d, a, nil, math.Inf(1))[a]; l.meters < north+side { t.Errorf("car D->A took the oneway: %+v", l) }
+	if l := g.search(profileWalk, d, a, nil, math.Inf(1))[a]; math.Abs(l.meters-north) > 1 { t.Errorf("walk D->A = %+v", l) }
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Vector tiles ---
+//
+// GET /tiles/places/{z}/{x}/{y}.pbf serves approved places as a Mapbox
+// Vector Tile (spec v2) with a single "places" layer. Below
+// tileClusterMaxZoom nearby points are merged into cluster features on a
+// grid so low zooms stay light. The grid is aligned across tiles and
+// border cells are always clustered from all their points, so a cluster
+// drawn in a neighbour's buffer matches the one that tile draws itself.
+// Tiles are cached for tileCacheTTL and carry
+// an ETag of their content.
+
+const (
+	tileExtent         = 4096
+	tileBuffer         = 64 // Extent units drawn beyond the edge so icons aren't cut
+	tileClusterCell    = 128 // Divides tileExtent, so cells line up across tiles
+	tileClusterMaxZoom = 14 // From this zoom up every place is its own feature
+	tileMaxZoom        = 22
+	tileCacheMaxItems  = 20000
+)
+
+var tileCacheTTL = envDuration("TILE_CACHE_TTL", time.Minute)
+
+type cachedTile struct {
+	data    []byte
+	etag    string
+	expires time.Time
+}
+
+var tileCache = struct {
+	sync.Mutex
+	m map[string]cachedTile
+}{m: map[string]cachedTile{}}
+
+// tilePoint is a place projected into tile coordinates.
+type tilePoint struct {
+	id          int
+	lat, lng    float64
+	x, y        float64
+	name        string
+	category    string
+	rating      float64
+	ratingCount int
+}
+
+// --- Protobuf writing, just enough for MVT ---
+
+type pbWriter struct{ buf []byte }
+
+func (w *pbWriter) key(num, wire int) { w.buf = binary.AppendUvarint(w.buf, uint64(num<<3|wire)) }
+func (w *pbWriter) varint(num int, v uint64) { w.key(num, 0); w.buf = binary.AppendUvarint(w.buf, v) }
+func (w *pbWriter) bytes(num int, b []byte) {
+	w.key(num, 2)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+func (w *pbWriter) packed(num int, vs []uint32) {
+	var p []byte
+	for _, v := range vs { p = binary.AppendUvarint(p, uint64(v)) }
+	w.bytes(num, p)
+}
+
+func zigzag32(v int32) uint32 { return uint32((v << 1) ^ (v >> 31)) }
+
+// mvtLayer accumulates features, interning keys and values as the spec
+// requires.
+type mvtLayer struct {
+	features []byte
+	keys     []string
+	keyIdx   map[string]uint32
+	values   [][]byte
+	valueIdx map[string]uint32
+}
+
+func newMVTLayer() *mvtLayer {
+	return &mvtLayer{keyIdx: map[string]uint32{}, valueIdx: map[string]uint32{}}
+}
+
+func (l *mvtLayer) tag(key string, value interface{}) []uint32 {
+	k, ok := l.keyIdx[key]
+	if !ok {
+		k = uint32(len(l.keys))
+		l.keyIdx[key] = k
+		l.keys = append(l.keys, key)
+	}
+	var v pbWriter
+	switch val := value.(type) {
+	case string:
+		v.bytes(1, []byte(val))
+	case float64:
+		v.key(3, 1)
+		v.buf = binary.LittleEndian.AppendUint64(v.buf, math.Float64bits(val))
+	case int:
+		v.varint(5, uint64(val))
+	case bool:
+		b := uint64(0)
+		if val { b = 1 }
+		v.varint(7, b)
+	}
+	vi, ok := l.valueIdx[string(v.buf)]
+	if !ok {
+		vi = uint32(len(l.values))
+		l.valueIdx[string(v.buf)] = vi
+		l.values = append(l.values, v.buf)
+	}
+	return []uint32{k, vi}
+}
+
+// addPoint appends a POINT feature at tile coordinates x, y.
+func (l *mvtLayer) addPoint(id int, x, y float64, props [][2]interface{}) {
+	var f pbWriter
+	if id > 0 { f.varint(1, uint64(id)) }
+	var tags []uint32
+	for _, p := range props { tags = append(tags, l.tag(p[0].(string), p[1])...) }
+	f.packed(2, tags)
+	f.varint(3, 1) // POINT
+	// MoveTo with one point: command (1) | count (1) << 3
+	f.packed(4, []uint32{9, zigzag32(int32(math.Round(x))), zigzag32(int32(math.Round(y)))})
+	var wrapped pbWriter
+	wrapped.bytes(2, f.buf)
+	l.features = append(l.features, wrapped.buf...)
+}
+
+func (l *mvtLayer) encode(name string) []byte {
+	var layer pbWriter
+	layer.varint(15, 2)
+	layer.bytes(1, []byte(name))
+	layer.buf = append(layer.buf, l.features...)
+	for _, k := range l.keys { layer.bytes(3, []byte(k)) }
+	for _, v := range l.values { layer.bytes(4, v) }
+	layer.varint(5, tileExtent)
+	var tile pbWriter
+	tile.bytes(3, layer.buf)
+	return tile.buf
+}
+
+// tileBounds returns the lat/lng box covered by tile z/x/y.
+func tileBounds(z, x, y int) (minLat, minLng, maxLat, maxLng float64) {
+	n := math.Exp2(float64(z))
+	lat := func(ty float64) float64 { return math.Atan(math.Sinh(math.Pi*(1-2*ty/n))) * 180 / math.Pi }
+	return lat(float64(y + 1)), float64(x)/n*360 - 180, lat(float64(y)), float64(x+1)/n*360 - 180
+}
+
+// projectToTile maps lat/lng to extent units relative to tile z/x/y.
+func projectToTile(z, x, y int, lat, lng float64) (float64, float64) {
+	n := math.Exp2(float64(z))
+	latRad := lat * math.Pi / 180
+	px := (lng + 180) / 360 * n
+	py := (1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * n
+	return (px - float64(x)) * tileExtent, (py - float64(y)) * tileExtent
+}
+
+func buildPlaceTile(z, x, y int, lang string) ([]byte, error) {
+	minLat, minLng, maxLat, maxLng := tileBounds(z, x, y)
+	// Widen the query by the buffer, in degrees. When clustering, take the
+	// whole border cells so their clusters come out complete; the margin is
+	// doubled because degrees of latitude aren't linear in tile units.
+	pad := float64(tileBuffer)
+	if z < tileClusterMaxZoom { pad = 2 * tileClusterCell }
+	padLng := (maxLng - minLng) * pad / tileExtent
+	padLat := (maxLat - minLat) * pad / tileExtent
+	rows, err := db.Query(`SELECT p.id, p.name, p.lat, p.lng, COALESCE(p.category, ''),
+			COALESCE(AVG(c.rating) FILTER (WHERE c.rating > 0), 0), COUNT(c.rating) FILTER (WHERE c.rating > 0)
+		FROM places p LEFT JOIN comments c ON c.place_id = p.id
+		WHERE p.status = 'approved' AND p.lat BETWEEN $1 AND $2 AND p.lng BETWEEN $3 AND $4
+		GROUP BY p.id`, minLat-padLat, maxLat+padLat, minLng-padLng, maxLng+padLng)
+	if err != nil { return nil, err }
+	defer rows.Close()
+	var points []tilePoint
+	for rows.Next() {
+		var p tilePoint
+		var nameJSON []byte
+		rows.Scan(&p.id, &nameJSON, &p.lat, &p.lng, &p.category, &p.rating, &p.ratingCount)
+		var name map[string]string
+		json.Unmarshal(nameJSON, &name)
+		p.name = localizedName(name, lang)
+		points = append(points, p)
+	}
+	return encodePlaceTile(z, x, y, points), nil
+}
+
+// encodePlaceTile projects points into tile z/x/y and encodes the layer,
+// clustered below tileClusterMaxZoom.
+func encodePlaceTile(z, x, y int, points []tilePoint) []byte {
+	for i := range points { points[i].x, points[i].y = projectToTile(z, x, y, points[i].lat, points[i].lng) }
+	layer := newMVTLayer()
+	if z >= tileClusterMaxZoom {
+		for _, p := range points {
+			layer.addPoint(p.id, p.x, p.y, [][2]interface{}{{"name", p.name}, {"category", p.category}, {"rating", math.Round(p.rating*10) / 10}, {"rating_count", p.ratingCount}})
+		}
+		return layer.encode("places")
+	}
+
+	// Grid clustering: one feature per occupied cell, placed at the mean
+	// position of its points and labelled with the most common category
+	type cluster struct {
+		sumX, sumY float64
+		points     []tilePoint
+	}
+	cells := map[[2]int]*cluster{}
+	var order [][2]int
+	const lastCell = tileExtent / tileClusterCell
+	for _, p := range points {
+		cell := [2]int{int(math.Floor(p.x / tileClusterCell)), int(math.Floor(p.y / tileClusterCell))}
+		if cell[0] < -1 || cell[0] > lastCell || cell[1] < -1 || cell[1] > lastCell { continue }
+		c, ok := cells[cell]
+		if !ok {
+			c = &cluster{}
+			cells[cell] = c
+			order = append(order, cell)
+		}
+		c.sumX += p.x
+		c.sumY += p.y
+		c.points = append(c.points, p)
+	}
+	inBuffer := func(px, py float64) bool {
+		return px >= -tileBuffer && px < tileExtent+tileBuffer && py >= -tileBuffer && py < tileExtent+tileBuffer
+	}
+	for _, cell := range order {
+		c := cells[cell]
+		n := float64(len(c.points))
+		// Border cells belong to the neighbour; draw them only where they
+		// reach into the buffer
+		if !inBuffer(c.sumX/n, c.sumY/n) { continue }
+		if len(c.points) == 1 {
+			p := c.points[0]
+			layer.addPoint(p.id, p.x, p.y, [][2]interface{}{{"name", p.name}, {"category", p.category}, {"rating", math.Round(p.rating*10) / 10}, {"rating_count", p.ratingCount}})
+			continue
+		}
+		counts := map[string]int{}
+		top := ""
+		for _, p := range c.points {
+			counts[p.category]++
+			if counts[p.category] > counts[top] || (counts[p.category] == counts[top] && p.category < top) { top = p.category }
+		}
+		layer.addPoint(0, c.sumX/n, c.sumY/n, [][2]interface{}{{"cluster", true}, {"point_count", len(c.points)}, {"category", top}})
+	}
+	return layer.encode("places")
+}
+
+// placeTile returns the cached tile, rebuilding it once expired.
+func placeTile(z, x, y int, lang string) (cachedTile, error) {
+	key := strconv.Itoa(z) + "/" + strconv.Itoa(x) + "/" + strconv.Itoa(y) + "/" + lang
+	tileCache.Lock()
+	cached, ok := tileCache.m[key]
+	tileCache.Unlock()
+	if ok && time.Now().Before(cached.expires) { return cached, nil }
+
+	data, err := buildPlaceTile(z, x, y, lang)
+	if err != nil { return cachedTile{}, err }
+	h := fnv.New64a()
+	h.Write(data)
+	tile := cachedTile{data: data, etag: `"` + hex.EncodeToString(h.Sum(nil)) + `"`, expires: time.Now().Add(tileCacheTTL)}
+	tileCache.Lock()
+	// Crude bound: start over rather than track recency
+	if len(tileCache.m) >= tileCacheMaxItems { tileCache.m = map[string]cachedTile{} }
+	tileCache.m[key] = tile
+	tileCache.Unlock()
+	return tile, nil
+}
+
+// placeTilesHandler serves /tiles/places/{z}/{x}/{y}.pbf (optional ?lang=
+// for feature names).
+func placeTilesHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "GET" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tiles/places/"), ".pbf"), "/")
+	if len(parts) != 3 { http.NotFound(w, r); return }
+	z, err1 := strconv.Atoi(parts[0])
+	x, err2 := strconv.Atoi(parts[1])
+	y, err3 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil || z < 0 || z > tileMaxZoom || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z { http.NotFound(w, r); return }
+	lang := r.URL.Query().Get("lang")
+	if lang == "" { lang = "tr" }
+
+	tile, err := placeTile(z, x, y, lang)
+	if err != nil {
+		log.Printf("Tiles: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", tile.etag)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if r.Header.Get("If-None-Match") == tile.etag { w.WriteHeader(http.StatusNotModified); return }
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.Write(tile.data)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+type mvtFeature struct {
+	id    uint64
+	props map[string]interface{}
+	x, y  int64
+}
+
+// decodeMVT reads the single layer of a tile back, checking that keys and
+// values are interned.
+func decodeMVT(t *testing.T, data []byte) (name string, features []mvtFeature) {
+	t.Helper()
+	var layer []byte
+	if err := pbfFields(data, func(num int, _ uint64, b []byte) error {
+		if num == 3 { layer = b }
+		return nil
+	}); err != nil || layer == nil { t.Fatalf("no layer: %v", err) }
+
+	var keys []string
+	var values []interface{}
+	var raw [][]byte
+	var version, extent uint64
+	err := pbfFields(layer, func(num int, v uint64, b []byte) error {
+		switch num {
+		case 1: name = string(b)
+		case 2: raw = append(raw, b)
+		case 3: keys = append(keys, string(b))
+		case 4:
+			return pbfFields(b, func(num int, v uint64, s []byte) error {
+				switch num {
+				case 1: values = append(values, string(s))
+				case 3: values = append(values, math.Float64frombits(v))
+				case 5: values = append(values, int(v))
+				case 7: values = append(values, v == 1)
+				}
+				return nil
+			})
+		case 5: extent = v
+		case 15: version = v
+		}
+		return nil
+	})
+	if err != nil { t.Fatal(err) }
+	if version != 2 || extent != tileExtent { t.Errorf("version %d extent %d", version, extent) }
+	seenKeys, seenValues := map[string]bool{}, map[interface{}]bool{}
+	for _, k := range keys {
+		if seenKeys[k] { t.Errorf("key %q not interned", k) }
+		seenKeys[k] = true
+	}
+	for _, v := range values {
+		if seenValues[v] { t.Errorf("value %v not interned", v) }
+		seenValues[v] = true
+	}
+
+	for _, b := range raw {
+		f := mvtFeature{props: map[string]interface{}{}}
+		var tags, geometry []uint64
+		var geomType uint64
+		err := pbfFields(b, func(num int, v uint64, p []byte) error {
+			var err error
+			switch num {
+			case 1: f.id = v
+			case 2: tags, err = pbfPacked(p)
+			case 3: geomType = v
+			case 4: geometry, err = pbfPacked(p)
+			}
+			return err
+		})
+		if err != nil { t.Fatal(err) }
+		if geomType != 1 || len(geometry) != 3 || geometry[0] != 9 || len(tags)%2 != 0 { t.Fatalf("bad point feature: type %d geometry %v tags %v", geomType, geometry, tags) }
+		f.x, f.y = zigzag(geometry[1]), zigzag(geometry[2])
+		for i := 0; i < len(tags); i += 2 { f.props[keys[tags[i]]] = values[tags[i+1]] }
+		features = append(features, f)
+	}
+	return name, features
+}
+
+// tileLatLng is the inverse of projectToTile.
+func tileLatLng(z, x, y int, tx, ty float64) (lat, lng float64) {
+	n := math.Exp2(float64(z))
+	px, py := float64(x)+tx/tileExtent, float64(y)+ty/tileExtent
+	return math.Atan(math.Sinh(math.Pi*(1-2*py/n))) * 180 / math.Pi, px/n*360 - 180
+}
+
+func TestZigzag32(t *testing.T) {
+	for _, v := range []int32{0, -1, 1, -64, 4160, math.MinInt32, math.MaxInt32} {
+		if got := zigzag(uint64(zigzag32(v))); got != int64(v) { t.Errorf("zigzag32(%d) decodes to %d", v, got) }
+	}
+	if zigzag32(-1) != 1 || zigzag32(1) != 2 { t.Error("zigzag32 doesn't follow the protobuf mapping") }
+	if b := binary.AppendUvarint(nil, uint64(zigzag32(-2))); len(b) != 1 || b[0] != 3 { t.Errorf("zigzag32(-2) = %v", b) }
+}
+
+func TestPlaceTileBorderCluster(t *testing.T) {
+	const z, x, y = 10, 604, 387 // Around İstanbul
+	// Three points in the first cell of tile x+1, their mean 57 units past
+	// the seam so it falls in tile x's buffer; one point further in that
+	// tile and one well inside tile x
+	right := func(id int, tx, ty float64, category string) tilePoint {
+		lat, lng := tileLatLng(z, x+1, y, tx, ty)
+		return tilePoint{id: id, lat: lat, lng: lng, name: "place", category: category}
+	}
+	lat, lng := tileLatLng(z, x, y, 1000, 1000)
+	points := []tilePoint{
+		right(1, 20, 1940, "nature"), right(2, 50, 1990, "historical"), right(3, 100, 2030, "nature"),
+		right(4, 200, 2000, "nature"),
+		{id: 5, lat: lat, lng: lng, name: "Galata Kulesi", category: "historical", rating: 4.46, ratingCount: 12},
+	}
+
+	single := 0
+	clusters := func(tx int) []mvtFeature {
+		name, features := decodeMVT(t, encodePlaceTile(z, tx, y, append([]tilePoint(nil), points...)))
+		if name != "places" { t.Errorf("layer name %q", name) }
+		var out []mvtFeature
+		for _, f := range features {
+			if f.props["cluster"] == true { out = append(out, f) }
+			if f.id == 5 { single++ }
+			if f.id == 5 && (f.x != 1000 || f.y != 1000 || f.props["name"] != "Galata Kulesi" || f.props["rating"] != 4.5 || f.props["rating_count"] != 12) {
+				t.Errorf("single place = %+v", f)
+			}
+		}
+		return out
+	}
+	left, own := clusters(x), clusters(x+1)
+	if single != 1 { t.Errorf("place 5 drawn %d times, want only in tile x", single) }
+	if len(left) != 1 || len(own) != 1 { t.Fatalf("clusters: tile x %+v, tile x+1 %+v", left, own) }
+	l, r := left[0], own[0]
+	if l.x-tileExtent != r.x || l.y != r.y { t.Errorf("cluster at %d,%d in tile x but %d,%d in tile x+1", l.x-tileExtent, l.y, r.x, r.y) }
+	if r.x != 57 || r.y != 1987 { t.Errorf("cluster at %d,%d, want 57,1987", r.x, r.y) }
+	if l.props["point_count"] != 3 || r.props["point_count"] != 3 || l.props["category"] != "nature" || r.props["category"] != "nature" {
+		t.Errorf("cluster props: tile x %v, tile x+1 %v", l.props, r.props)
+	}
+}
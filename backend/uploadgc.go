@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// --- Orphaned upload garbage collection ---
+//
+// Every upload gets a row in the uploads table with its owner and the
+// number of places/avatars pointing at it. Handlers keep ref_count up to
+// date as references change, and the sweeper recounts from the real tables
+// before deleting anything, so a missed update can never remove a file
+// that's still in use. Photos in place history and pending suggested edits
+// count too, so a rollback never points at a deleted file.
+
+var uploadGCGrace = envDuration("UPLOAD_GC_GRACE", 24*time.Hour)
+var uploadGCInterval = envDuration("UPLOAD_GC_INTERVAL", time.Hour)
+
+// OrphanUpload is one entry of the sweeper report.
+type OrphanUpload struct {
+	Key       string    `json:"key"`
+	URL       string    `json:"url"`
+	OwnerID   int       `json:"owner_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func initUploadTables() {
+	db.Exec(`CREATE TABLE IF NOT EXISTS uploads (
+		key TEXT PRIMARY KEY,
+		owner_id INT REFERENCES users(id) ON DELETE SET NULL,
+		ref_count INT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(getEnv(key, "")); err == nil && d > 0 { return d }
+	return fallback
+}
+
+// trackUpload records a freshly stored upload with no references yet.
+func trackUpload(key string, ownerID int) {
+	if _, err := db.Exec("INSERT INTO uploads (key, owner_id) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING", key, ownerID); err != nil {
+		log.Printf("Upload GC: could not track %s: %v", key, err)
+	}
+}
+
+// retainUpload and releaseUpload adjust the reference count of the upload
+// behind url. External URLs are ignored.
+func retainUpload(url string) {
+	if key := blobKeyFromURL(url); key != "" {
+		db.Exec("UPDATE uploads SET ref_count = ref_count + 1 WHERE key = $1", key)
+	}
+}
+
+func releaseUpload(url string) {
+	if key := blobKeyFromURL(url); key != "" {
+		db.Exec("UPDATE uploads SET ref_count = GREATEST(ref_count - 1, 0) WHERE key = $1", key)
+	}
+}
+
+// reconcileUploads adopts stored files the table doesn't know about (e.g.
+// uploads made before tracking existed) and recounts every reference.
+func reconcileUploads(ctx context.Context) error {
+	stored, err := blobs.List(ctx)
+	if err != nil { return err }
+	for _, b := range stored {
+		if isVariantKey(b.Key) { continue }
+		db.Exec("INSERT INTO uploads (key, created_at) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING", b.Key, b.ModTime)
+	}
+	_, err = db.Exec(`
+		UPDATE uploads u SET ref_count =
+			(SELECT COUNT(*) FROM places p WHERE right(p.image_url, length(u.key) + 1) = '/' || u.key) +
+			(SELECT COUNT(*) FROM users us WHERE right(us.avatar_url, length(u.key) + 1) = '/' || u.key) +
+			(SELECT COUNT(*) FROM place_revisions r WHERE right(r.data->>'imageUrl', length(u.key) + 1) = '/' || u.key) +
+			(SELECT COUNT(*) FROM place_edits e WHERE e.status = 'pending' AND right(e.changes->'imageUrl'->>'new', length(u.key) + 1) = '/' || u.key)`)
+	return err
+}
+
+// findOrphanUploads lists unreferenced uploads older than the grace period.
+func findOrphanUploads(grace time.Duration) ([]OrphanUpload, error) {
+	rows, err := db.Query("SELECT key, COALESCE(owner_id, 0), created_at FROM uploads WHERE ref_count = 0 AND created_at < $1 ORDER BY created_at ASC", time.Now().Add(-grace))
+	if err != nil { return nil, err }
+	defer rows.Close()
+	orphans := []OrphanUpload{}
+	for rows.Next() {
+		var o OrphanUpload
+		rows.Scan(&o.Key, &o.OwnerID, &o.CreatedAt)
+		o.URL = blobs.URL(o.Key)
+		orphans = append(orphans, o)
+	}
+	return orphans, nil
+}
+
+// sweepOrphanUploads deletes orphaned uploads and their variants. With
+// dryRun it only reports what would be deleted.
+func sweepOrphanUploads(ctx context.Context, dryRun bool) ([]OrphanUpload, error) {
+	if err := reconcileUploads(ctx); err != nil { return nil, err }
+	orphans, err := findOrphanUploads(uploadGCGrace)
+	if err != nil || dryRun { return orphans, err }
+	deleted := []OrphanUpload{}
+	for _, o := range orphans {
+		// The row delete is the lock: if something referenced the file
+		// since the report was built, ref_count is no longer 0.
+		res, err := db.Exec("DELETE FROM uploads WHERE key = $1 AND ref_count = 0", o.Key)
+		if err != nil { continue }
+		if n, _ := res.RowsAffected(); n == 0 { continue }
+		for _, key := range append(variantKeys(o.Key), o.Key) {
+			if err := blobs.Delete(ctx, key); err != nil { log.Printf("Upload GC: delete %s: %v", key, err) }
+		}
+		deleted = append(deleted, o)
+	}
+	return deleted, nil
+}
+
+// startUploadSweeper runs the sweeper in the background for the life of
+// the process.
+func startUploadSweeper() {
+	go func() {
+		ticker := time.NewTicker(uploadGCInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			deleted, err := sweepOrphanUploads(context.Background(), false)
+			if err != nil { log.Printf("Upload GC: %v", err); continue }
+			if len(deleted) > 0 { log.Printf("Upload GC: removed %d orphaned uploads", len(deleted)) }
+		}
+	}()
+}
+
+// uploadGCAdmin backs /api/admin?action=upload-gc. GET is a dry run that
+// lists what the next sweep would delete, POST sweeps immediately.
+func uploadGCAdmin(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.Method == "GET"
+	orphans, err := sweepOrphanUploads(r.Context(), dryRun)
+	if err != nil { http.Error(w, "Database error", http.StatusInternalServerError); return }
+	json.NewEncoder(w).Encode(map[string]interface{}{"dry_run": dryRun, "grace_period": uploadGCGrace.String(), "uploads": orphans})
+}
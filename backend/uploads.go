@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+// --- Upload limits ---
+
+const (
+	uploadDir         = "uploads"
+	maxUploadBytes    = 10 << 20 // Hard cap on the request body
+	maxImageDimension = 8000     // Max width or height in pixels
+	maxImagePixels    = 40000000 // Max width*height, stops decompression bombs
+	jpegQuality       = 85
+)
+
+// allowedImageTypes maps sniffed MIME types to the extension the re-encoded
+// file is stored with. WebP has no encoder in the stdlib so it becomes JPEG,
+// GIFs are flattened to their first frame and stored as PNG.
+var allowedImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".png",
+	"image/webp": ".jpg",
+}
+
+var errImageTooLarge = errors.New("image dimensions exceed limit")
+var errUnsupportedImage = errors.New("unsupported image type")
+
+// sanitizeImage sniffs the content type from the magic bytes, checks the
+// declared pixel dimensions before decoding anything, then decodes and
+// re-encodes the image. Re-encoding drops EXIF/GPS metadata and anything
+// appended after the image data. The decoded image is returned so callers
+// can derive resized variants without decoding again.
+func sanitizeImage(src io.Reader) (image.Image, []byte, string, error) {
+	br := bufio.NewReader(src)
+	head, _ := br.Peek(512)
+	mimeType := http.DetectContentType(head)
+	ext, ok := allowedImageTypes[mimeType]
+	if !ok { return nil, nil, "", errUnsupportedImage }
+
+	raw, err := io.ReadAll(br)
+	if err != nil { return nil, nil, "", err }
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil { return nil, nil, "", errUnsupportedImage }
+	// Sniffed type and decoder must agree, otherwise this is a polyglot file
+	if "image/"+format != mimeType { return nil, nil, "", errUnsupportedImage }
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > maxImageDimension || cfg.Height > maxImageDimension || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, nil, "", errImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil { return nil, nil, "", errUnsupportedImage }
+
+	data, err := encodeImage(img, ext)
+	if err != nil { return nil, nil, "", err }
+	return img, data, ext, nil
+}
+
+// encodeImage writes img in the format implied by ext.
+func encodeImage(img image.Image, ext string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch ext {
+	case ".png":
+		err = png.Encode(&buf, img)
+	case ".gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil { return nil, err }
+	return buf.Bytes(), nil
+}
+
+// randomName returns an unguessable hex identifier for stored files.
+func randomName() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	if r.Method == "OPTIONS" { return }
+	if r.Method != "POST" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	userID, _ := currentUser(r)
+	if userID == 0 { http.Error(w, "Unauthorized", http.StatusUnauthorized); return }
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	if err := r.ParseMultipartForm(maxUploadBytes); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) { http.Error(w, "File too large", http.StatusRequestEntityTooLarge); return }
+		http.Error(w, "Invalid multipart form", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	file, _, err := r.FormFile("image")
+	if err != nil { http.Error(w, "Error retrieving file", http.StatusBadRequest); return }
+	defer file.Close()
+
+	img, data, ext, err := sanitizeImage(file)
+	if err != nil {
+		if errors.Is(err, errImageTooLarge) { http.Error(w, "Image dimensions too large", http.StatusRequestEntityTooLarge); return }
+		http.Error(w, "Invalid file type", http.StatusBadRequest)
+		return
+	}
+
+	filename := randomName() + ext
+	if err := blobs.Put(r.Context(), filename, data, contentTypeForKey(filename)); err != nil {
+		log.Printf("Upload: write failed: %v", err)
+		http.Error(w, "Error saving file", http.StatusInternalServerError)
+		return
+	}
+	trackUpload(filename, userID)
+	// Variants are regenerated lazily on request if this fails
+	if err := generateVariants(r.Context(), filename, img); err != nil { log.Printf("Upload: variants for %s failed: %v", filename, err) }
+	fileURL := blobs.URL(filename)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"url": fileURL})
+}
+
+// serveUploadHandler serves stored images without directory listings and
+// with headers that stop browsers from sniffing them into anything else.
+// A ?w= parameter selects a resized variant. Stored files never change, so
+// responses are cacheable forever. Stores that serve objects themselves
+// (S3) get a redirect to a presigned URL instead of being proxied.
+func serveUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" { http.Error(w, "Method not allowed", http.StatusMethodNotAllowed); return }
+	name := strings.TrimPrefix(r.URL.Path, "/uploads/")
+	if !validBlobKey(name) || contentTypeForKey(name) == "" { http.NotFound(w, r); return }
+	if !verifyUploadSignature(name, r.URL.Query()) { http.Error(w, "Link expired", http.StatusForbidden); return }
+	key, err := variantKey(name, r)
+	if err != nil { http.NotFound(w, r); return }
+
+	if signed, err := blobs.SignedURL(key, signedURLTTL); err == nil && strings.HasPrefix(signed, "http") {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(signedURLTTL.Seconds())/2))
+		http.Redirect(w, r, signed, http.StatusFound)
+		return
+	}
+
+	rc, info, err := blobs.Get(r.Context(), key)
+	if err != nil { http.NotFound(w, r); return }
+	defer rc.Close()
+	w.Header().Set("Content-Type", contentTypeForKey(key))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size))
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, info.ModTime, rs)
+		return
+	}
+	raw, err := io.ReadAll(rc)
+	if err != nil { http.Error(w, "Error reading file", http.StatusInternalServerError); return }
+	http.ServeContent(w, r, key, info.ModTime, bytes.NewReader(raw))
+}
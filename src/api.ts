@@ -40,8 +40,32 @@ export const uploadImage = async (file: File) => {
   return response.data.url;
 };
 
-export const getNearbyPlaces = async (lat: number, lng: number, radiusKm: number = 10) => {
-    const response = await api.get<any[]>(`/places?lat=${lat}&lng=${lng}&radius=${radiusKm}`);
+// Crop region is in source image pixels; omitted means a centered square
+export const uploadAvatar = async (file: File, crop?: { x: number; y: number; size: number }) => {
+  const formData = new FormData();
+  formData.append('image', file);
+  if (crop) {
+    formData.append('x', String(crop.x));
+    formData.append('y', String(crop.y));
+    formData.append('size', String(crop.size));
+  }
+
+  const response = await api.post('/user?action=avatar', formData, {
+    headers: {
+      'Content-Type': 'multipart/form-data'
+    }
+  });
+  return response.data as { avatar_url: string; avatars: { small: string; medium: string; large: string } };
+};
+
+// With travel, results carry road travel_km/travel_minutes and are ordered by them
+export const getNearbyPlaces = async (lat: number, lng: number, radiusKm: number = 10, travel?: { profile: 'car' | 'walk'; maxMinutes?: number }) => {
+    const params: Record<string, string | number> = { lat, lng, radius: radiusKm };
+    if (travel) {
+        params.travel = travel.profile;
+        if (travel.maxMinutes) params.max_minutes = travel.maxMinutes;
+    }
+    const response = await api.get<any[]>('/places', { params });
     return response.data;
 };
 
@@ -50,6 +74,34 @@ export const getUserComments = async () => {
     return response.data;
 };
 
+export interface Category {
+    slug: string;
+    labels: Record<string, string>;
+    icon: string;
+    parent?: string;
+    visit_minutes: number;
+    position: number;
+}
+
+export const getCategories = async () => {
+    const response = await api.get<Category[]>('/categories');
+    return response.data;
+};
+
+// Admin only; PUT when slug is given, otherwise POST
+export const saveCategory = async (category: Category, slug?: string) => {
+    const response = slug
+        ? await api.put<Category>('/categories', category, { params: { slug } })
+        : await api.post<Category>('/categories', category);
+    return response.data;
+};
+
+// Places in the deleted category move to replace (default "other")
+export const deleteCategory = async (slug: string, replace?: string) => {
+    const response = await api.delete<{ deleted: string; replacement: string; places_moved: number }>('/categories', { params: { slug, replace } });
+    return response.data;
+};
+
 export const getAdminStats = async () => {
     const response = await api.get<any>('/admin?action=stats');
     return response.data;
@@ -63,16 +115,233 @@ export const setFavoriteStatus = async (placeId: number, shouldBeFavorite: boole
     }
 };
 
+// Diff keys are fields, or name.<lang> / description.<lang>; old is missing for added fields, new for removed ones
+export type PlaceDiff = Record<string, { old?: unknown; new?: unknown }>;
+
+export interface PlaceRevision {
+    id: number;
+    place_id: number;
+    user_id?: number;
+    username?: string;
+    action: 'baseline' | 'create' | 'edit' | 'suggestion' | 'rollback';
+    edit_id?: number;
+    diff: PlaceDiff;
+    data?: any;
+    created_at: string;
+}
+
+export interface PlaceEdit {
+    id: number;
+    place_id: number;
+    user_id: number;
+    username: string;
+    changes: PlaceDiff;
+    note: string;
+    status: 'pending' | 'approved' | 'rejected';
+    reviewed_by?: number;
+    reviewed_at?: string;
+    created_at: string;
+    conflicts?: string[];
+}
+
+// name/description are per-language maps and only the given languages change; non-owners get a pending PlaceEdit back instead of a revision
+export const updatePlace = async (id: number, changes: Record<string, unknown> & { note?: string }) => {
+    const response = await api.put<PlaceRevision | PlaceEdit>('/places', changes, { params: { id } });
+    return { suggested: response.status === 202, data: response.data };
+};
+
+export const getPlaceRevisions = async (id: number) =>
+    (await api.get<PlaceRevision[]>('/admin', { params: { action: 'revisions', id } })).data;
+
+export const rollbackPlace = async (placeId: number, revisionId: number) =>
+    (await api.post<PlaceRevision>('/admin?action=rollback', { place_id: placeId, revision_id: revisionId })).data;
+
+export const getPlaceEdits = async (params: { status?: 'pending' | 'approved' | 'rejected'; place_id?: number } = {}) =>
+    (await api.get<PlaceEdit[]>('/admin', { params: { action: 'edits', ...params } })).data;
+
+// Approval answers 409 with the conflicting fields unless force is set
+export const reviewPlaceEdit = async (id: number, approve: boolean, force = false) =>
+    (await api.post(`/admin?action=${approve ? 'approve-edit' : 'reject-edit'}`, { id, force })).data;
+
+export type ModerationAction = 'approve' | 'reject' | 'request-changes' | 'archive';
+
+// Everything but approve needs a reason; each place is reported separately
+export const moderatePlaces = async (action: ModerationAction, ids: number[], reason = '') =>
+    (await api.post<{ results: { id: number; status?: string; error?: string }[] }>(`/admin?action=${action}`, { ids, reason })).data.results;
+
+export interface Notification {
+    id: number;
+    kind: 'place_moderated' | 'edit_reviewed';
+    ref_type?: string;
+    ref_id?: number;
+    data: Record<string, any>;
+    read: boolean;
+    created_at: string;
+}
+
+export const getNotifications = async (unreadOnly = false) =>
+    (await api.get<{ notifications: Notification[]; unread: number }>('/notifications', { params: unreadOnly ? { unread: 1 } : {} })).data;
+
+// Without ids every notification is marked read
+export const markNotificationsRead = async (ids: number[] = []) => {
+    await api.post('/notifications?action=read', { ids });
+};
+
 export const getFavorites = async () => {
     const response = await api.get<any[]>('/favorites');
     return response.data;
 };
 
-export const getLeaderboard = async () => {
-    const response = await api.get<any[]>('/leaderboard');
+export const getLeaderboard = async (params: { window?: 'week' | 'month' | 'all'; city?: string; category?: string; limit?: number; offset?: number } = {}) => {
+    const response = await api.get<{ entries: any[]; me?: any; total: number }>('/leaderboard', { params });
+    return response.data.entries;
+};
+
+export const planRoute = async (params: { lat: number; lng: number; budget_minutes: number; interests: string[]; visit_minutes?: Record<string, number>; return_to_start?: boolean; must_visit?: number[] }) => {
+    const response = await api.post<{ stops: { place: any; arrive_minute: number; depart_minute: number; travel_minutes: number; visit_minutes: number }[]; total_minutes: number; distance_km: number }>('/planner', params);
+    return response.data;
+};
+
+export interface SavedRoute {
+    id?: number;
+    title: string;
+    notes?: string;
+    visibility?: 'private' | 'unlisted' | 'public';
+    share_slug?: string;
+    place_ids: number[];
+    stops?: any[];
+}
+
+export const getRoutes = async (publicOnly = false) => {
+    const response = await api.get<SavedRoute[]>('/routes', { params: publicOnly ? { public: 1 } : {} });
     return response.data;
 };
 
+export const getRoute = async (ref: { id?: number; slug?: string }) => {
+    const response = await api.get<SavedRoute>('/routes', { params: ref });
+    return response.data;
+};
+
+export const saveRoute = async (route: SavedRoute) => {
+    const response = route.id
+        ? await api.put<SavedRoute>('/routes', route, { params: { id: route.id } })
+        : await api.post<SavedRoute>('/routes', route);
+    return response.data;
+};
+
+export const deleteRoute = async (id: number) => {
+    await api.delete('/routes', { params: { id } });
+};
+
+export const forkRoute = async (ref: { id?: number; slug?: string }) => {
+    const response = await api.post<SavedRoute>('/routes', null, { params: { action: 'fork', ...ref } });
+    return response.data;
+};
+
+export const routeExportUrl = (ref: { id?: number; slug?: string }, format: 'gpx' | 'kml', lang?: string) => {
+    const params = new URLSearchParams({ format, ...(ref.id ? { id: String(ref.id) } : {}), ...(ref.slug ? { slug: ref.slug } : {}), ...(lang ? { lang } : {}) });
+    return `${API_BASE_URL}/routes?${params}`;
+};
+
+export interface PlaceList {
+    id?: number;
+    title: string;
+    description?: string;
+    visibility?: 'private' | 'public';
+    owner?: string;
+    entry_count?: number;
+    follower_count?: number;
+    following?: boolean;
+    can_edit?: boolean;
+    collaborators?: string[];
+    entries?: (any & { position: number; note: string; added_by: string })[];
+}
+
+export const getLists = async (params: { user_id?: number; followed?: boolean } = {}) => {
+    const response = await api.get<PlaceList[]>('/lists', { params: { ...params, followed: params.followed ? 1 : undefined } });
+    return response.data;
+};
+
+export const getList = async (id: number) => (await api.get<PlaceList>('/lists', { params: { id } })).data;
+
+export const saveList = async (list: PlaceList) => {
+    const response = list.id
+        ? await api.put<PlaceList>('/lists', list, { params: { id: list.id } })
+        : await api.post<PlaceList>('/lists', list);
+    return response.data;
+};
+
+export const deleteList = async (id: number) => {
+    await api.delete('/lists', { params: { id } });
+};
+
+export const addToList = async (id: number, placeId: number, note = '') =>
+    (await api.post<PlaceList>('/lists', { place_id: placeId, note }, { params: { id, action: 'add' } })).data;
+
+export const updateListNote = async (id: number, placeId: number, note: string) =>
+    (await api.put<PlaceList>('/lists', { place_id: placeId, note }, { params: { id, action: 'entry' } })).data;
+
+export const removeFromList = async (id: number, placeId: number) =>
+    (await api.delete<PlaceList>('/lists', { params: { id, action: 'remove', place_id: placeId } })).data;
+
+export const reorderList = async (id: number, placeIds: number[]) =>
+    (await api.put<PlaceList>('/lists', { place_ids: placeIds }, { params: { id, action: 'reorder' } })).data;
+
+export const inviteToList = async (id: number, username: string) =>
+    (await api.post<PlaceList>('/lists', { username }, { params: { id, action: 'invite' } })).data;
+
+export const removeCollaborator = async (id: number, username: string) =>
+    (await api.delete<PlaceList>('/lists', { params: { id, action: 'collaborator', username } })).data;
+
+export const setListFollow = async (id: number, follow: boolean) => {
+    const config = { params: { id, action: 'follow' } };
+    return (follow ? await api.post<PlaceList>('/lists', null, config) : await api.delete<PlaceList>('/lists', config)).data;
+};
+
+// Export is a download, so this returns a URL; favorites need the auth header and go through exportPlaces
+export const exportUrl = (format: 'geojson' | 'kml' | 'gpx', params: { scope?: 'places' | 'list'; id?: number; city?: string; category?: string; bbox?: string; lang?: string } = {}) => {
+    const query = new URLSearchParams({ format });
+    Object.entries(params).forEach(([key, value]) => { if (value !== undefined && value !== '') query.set(key, String(value)); });
+    return `${API_BASE_URL}/export?${query}`;
+};
+
+export const exportPlaces = async (format: 'geojson' | 'kml' | 'gpx', params: { scope?: 'places' | 'favorites' | 'list'; id?: number; city?: string; category?: string; bbox?: string; lang?: string } = {}) => {
+    const response = await api.get<Blob>('/export', { params: { format, ...params }, responseType: 'blob' });
+    return response.data;
+};
+
+export interface GeocodeResult {
+    name: string;
+    display_name: string;
+    lat: number;
+    lng: number;
+    city?: string;
+    district?: string;
+    place_id?: number;
+    source: 'nominatim' | 'place' | 'province' | 'gazetteer';
+}
+
+// Address search goes through the backend so queries aren't sent from the browser to third parties
+export const geocode = async (q: string, params: { lang?: string; limit?: number } = {}) => {
+    const response = await api.get<GeocodeResult[]>('/geocode', { params: { q, ...params } });
+    return response.data;
+};
+
+// Vector tile template for map libraries; features carry name, category, rating and rating_count, or cluster and point_count below zoom 14
+export const placeTilesUrl = (lang = 'tr') => `/tiles/places/{z}/{x}/{y}.pbf?lang=${lang}`;
+
+// Dry run returns the preview (errors and duplicate candidates per row) without creating anything
+export const importPlaces = async (file: File, format: 'geojson' | 'kml' | 'csv', options: { dryRun?: boolean; skipDuplicates?: boolean } = {}) => {
+    const formData = new FormData();
+    formData.append('file', file);
+    const response = await api.post('/import', formData, {
+        params: { format, dry_run: options.dryRun ? 1 : undefined, skip_duplicates: options.skipDuplicates ? 1 : undefined },
+        headers: { 'Content-Type': 'multipart/form-data' },
+        validateStatus: status => status < 300 || status === 422,
+    });
+    return response.data as { dry_run: boolean; total: number; invalid: number; duplicates: number; created: number; rows: any[] };
+};
+
 export const translateText = async (text: string, from: string, to: string) => {
     try {
         const response = await axios.get(`https://lingva.dialectapp.org/api/v1/${from}/${to}/${encodeURIComponent(text)}`);
@@ -0,0 +1,26 @@
+import { ref } from 'vue';
+import { getCategories, type Category } from './api';
+import { getLocalizedContent } from './utils';
+
+// Category taxonomy from the API, loaded once and shared by every component
+export const categories = ref<Category[]>([]);
+
+let loading: Promise<void> | null = null;
+
+export function loadCategories() {
+    if (!loading) {
+        loading = getCategories()
+            .then(list => { categories.value = list; })
+            .catch(error => { console.error('Error fetching categories:', error); loading = null; });
+    }
+    return loading;
+}
+
+export function categoryLabel(slug: string, locale: string) {
+    const category = categories.value.find(c => c.slug === slug);
+    return category ? getLocalizedContent(category.labels, locale) : slug;
+}
+
+export function categoryIcon(slug: string) {
+    return categories.value.find(c => c.slug === slug)?.icon || '📍';
+}
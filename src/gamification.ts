@@ -1,4 +1,6 @@
 
+import { getLocalizedContent } from './utils';
+
 export interface Rank {
     title: string;
     icon: string;
@@ -6,6 +8,29 @@ export interface Rank {
     color: string;
 }
 
+// Rank ladder as returned by the backend (/api/ranks and User.rank)
+export interface ServerRank {
+    id: string;
+    icon: string;
+    color: string;
+    min_points: number;
+    title: Record<string, string>;
+    privileges: string[];
+}
+
+export interface RankInfo {
+    current: ServerRank;
+    next: ServerRank | null;
+    progress: number;
+    points_to_next: number;
+    privileges: string[];
+}
+
+export function fromServerRank(rank: ServerRank, locale: string): Rank {
+    return { title: getLocalizedContent(rank.title, locale), icon: rank.icon, minPoints: rank.min_points, color: rank.color };
+}
+
+// Fallback only; the backend is authoritative for ranks
 export const RANKS: Rank[] = [
     { title: 'Yeni Kaşif', icon: '🌱', minPoints: 0, color: 'text-emerald-500' },
     { title: 'Yerel Rehber', icon: '🧭', minPoints: 100, color: 'text-blue-500' },
@@ -34,8 +59,7 @@ export function getProgress(points: number): number {
     return Math.min(Math.round((currentProgress / totalGap) * 100), 100);
 }
 
-// Simulating points for now (In a real app, this comes from backend)
+// Points are derived from the backend points ledger
 export function getUserPoints(user: any): number {
-    // If backend has points, use it. If not, generate a deterministic fake score based on username length for demo
-    return user.points || (user.username.length * 15) + 20;
+    return user?.points ?? 0;
 }
@@ -21,6 +21,11 @@ export default defineConfig({
         target: 'http://127.0.0.1:8080',
         changeOrigin: true,
         secure: false
+      },
+      '/tiles': {
+        target: 'http://127.0.0.1:8080',
+        changeOrigin: true,
+        secure: false
       }
     },
     watch: {